@@ -0,0 +1,278 @@
+// Package analysis implements states graph analysis for gfsm state machines: reachability, shortest paths,
+// strongly connected components, dead ends and unreachable states.
+//
+// The graph is built from gfsm.Description, which is provided by both StateMachineBuilder and StateMachineHandler:
+//
+//	g := analysis.New(sm.Describe())
+//	path, ok := g.ShortestPath(Init, Commit)
+//
+// A state is connected to each of its allowed transitions targets. For hierarchical state machines, a state is also
+// connected to the transitions targets of its ancestors, as transitions of the parent are valid from the sub-state,
+// and a composite state is connected to its default sub-state (or to all the regions for parallel states), as they
+// are entered together. A sub-state is not connected to its parent, as the parent is active already, and it is not
+// entered again unless a transition targets it.
+// Transitions to a history pseudo-state connect to its parent state, and transitions to a choice or junction
+// connect to all its branch targets.
+package analysis
+
+import (
+	"slices"
+
+	"github.com/astavonin/gfsm"
+)
+
+// Graph is the states graph of a state machine.
+type Graph[StateIdentifier comparable] struct {
+	defaultState StateIdentifier
+	// states keeps registration order
+	states  []StateIdentifier
+	final   map[StateIdentifier]bool
+	leaf    map[StateIdentifier]bool
+	parents map[StateIdentifier]StateIdentifier
+	// transitions are the allowed transitions of each state, and edges also include hierarchy links
+	transitions map[StateIdentifier][]StateIdentifier
+	edges       map[StateIdentifier][]StateIdentifier
+}
+
+// New creates the states graph of the described state machine.
+func New[StateIdentifier comparable](d gfsm.Description[StateIdentifier]) *Graph[StateIdentifier] {
+	g := &Graph[StateIdentifier]{
+		defaultState: d.DefaultState,
+		final:        map[StateIdentifier]bool{},
+		leaf:         map[StateIdentifier]bool{},
+		parents:      map[StateIdentifier]StateIdentifier{},
+		transitions:  map[StateIdentifier][]StateIdentifier{},
+		edges:        map[StateIdentifier][]StateIdentifier{},
+	}
+	registered := map[StateIdentifier]bool{}
+	transitions := map[StateIdentifier][]StateIdentifier{}
+	for _, st := range d.States {
+		registered[st.ID] = true
+		transitions[st.ID] = st.Transitions
+		if st.HasParent {
+			g.parents[st.ID] = st.Parent
+		}
+	}
+	pseudoTargets := map[StateIdentifier][]StateIdentifier{}
+	for _, pseudo := range d.PseudoStates {
+		switch pseudo.Kind {
+		case gfsm.ShallowHistory, gfsm.DeepHistory:
+			pseudoTargets[pseudo.ID] = []StateIdentifier{pseudo.Parent}
+		default:
+			pseudoTargets[pseudo.ID] = pseudo.Transitions
+		}
+	}
+	for _, st := range d.States {
+		g.states = append(g.states, st.ID)
+		g.final[st.ID] = st.Final
+		g.leaf[st.ID] = !st.Composite
+		g.transitions[st.ID] = st.Transitions
+
+		var edges []StateIdentifier
+		// passed keeps pseudo-states already followed, as junctions can be chained
+		passed := map[StateIdentifier]bool{}
+		var add func(targetID StateIdentifier)
+		add = func(targetID StateIdentifier) {
+			if targets, ok := pseudoTargets[targetID]; ok {
+				if !passed[targetID] {
+					passed[targetID] = true
+					for _, pseudoTargetID := range targets {
+						add(pseudoTargetID)
+					}
+				}
+				return
+			}
+			if targetID == st.ID || !registered[targetID] {
+				return
+			}
+			for _, edge := range edges {
+				if edge == targetID {
+					return
+				}
+			}
+			edges = append(edges, targetID)
+		}
+		for ancestorID, ok := st.ID, true; ok; ancestorID, ok = g.parentOf(ancestorID) {
+			for _, targetID := range transitions[ancestorID] {
+				add(targetID)
+			}
+		}
+		switch {
+		case st.Parallel:
+			for _, regionID := range st.SubStates {
+				add(regionID)
+			}
+		case st.Composite:
+			add(st.DefaultSubState)
+		}
+		g.edges[st.ID] = edges
+	}
+	return g
+}
+
+// Reachable reports whether the state to can become active after a sequence of transitions starting in from.
+// A state is always reachable from itself. The ancestors of from are active already, they are reachable only if
+// a transition enters them again.
+func (g *Graph[StateIdentifier]) Reachable(from, to StateIdentifier) bool {
+	_, ok := g.ShortestPath(from, to)
+	return ok
+}
+
+// ShortestPath returns the shortest sequence of states leading from the state from to the state to, both included.
+// The second return value is false if there is no such path.
+func (g *Graph[StateIdentifier]) ShortestPath(from, to StateIdentifier) ([]StateIdentifier, bool) {
+	if _, ok := g.edges[from]; !ok {
+		return nil, false
+	}
+	prev := map[StateIdentifier]StateIdentifier{from: from}
+	queue := []StateIdentifier{from}
+	for len(queue) > 0 && queue[0] != to {
+		stateID := queue[0]
+		queue = queue[1:]
+		for _, nextID := range g.edges[stateID] {
+			if _, ok := prev[nextID]; !ok {
+				prev[nextID] = stateID
+				queue = append(queue, nextID)
+			}
+		}
+	}
+	if _, ok := prev[to]; !ok {
+		return nil, false
+	}
+
+	path := []StateIdentifier{to}
+	for stateID := to; stateID != from; {
+		stateID = prev[stateID]
+		path = append(path, stateID)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, true
+}
+
+// Unreachable returns states which cannot become active starting from the default state, in registration order.
+// The ancestors of a reachable state are reachable as well, as they are active together with it.
+func (g *Graph[StateIdentifier]) Unreachable() []StateIdentifier {
+	var unreachable []StateIdentifier
+	reached := g.reachableFrom(g.defaultState)
+	for stateID := range reached {
+		for ancestorID, ok := g.parentOf(stateID); ok && !reached[ancestorID]; ancestorID, ok = g.parentOf(ancestorID) {
+			// the ancestors are not entered, so their default sub-states are not reached through them
+			reached[ancestorID] = true
+		}
+	}
+	for _, stateID := range g.states {
+		if !reached[stateID] {
+			unreachable = append(unreachable, stateID)
+		}
+	}
+	return unreachable
+}
+
+// DeadEnds returns leaf states which are not final and have no way out, in registration order. A state has a way
+// out if it or any of its ancestors has a transition to another state.
+func (g *Graph[StateIdentifier]) DeadEnds() []StateIdentifier {
+	var deadEnds []StateIdentifier
+	for _, stateID := range g.states {
+		if !g.leaf[stateID] || g.final[stateID] || g.hasWayOut(stateID) {
+			continue
+		}
+		deadEnds = append(deadEnds, stateID)
+	}
+	return deadEnds
+}
+
+func (g *Graph[StateIdentifier]) hasWayOut(stateID StateIdentifier) bool {
+	for ancestorID, ok := stateID, true; ok; ancestorID, ok = g.parentOf(ancestorID) {
+		for _, targetID := range g.transitions[ancestorID] {
+			if targetID != stateID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Graph[StateIdentifier]) parentOf(stateID StateIdentifier) (StateIdentifier, bool) {
+	parentID, ok := g.parents[stateID]
+	return parentID, ok
+}
+
+// StronglyConnectedComponents returns groups of states which are reachable from each other. Components are listed
+// in reverse topological order: no state of a component has a path to the components listed after it. States
+// inside a component keep registration order.
+func (g *Graph[StateIdentifier]) StronglyConnectedComponents() [][]StateIdentifier {
+	// Tarjan's algorithm
+	index := map[StateIdentifier]int{}
+	lowLink := map[StateIdentifier]int{}
+	onStack := map[StateIdentifier]bool{}
+	position := map[StateIdentifier]int{}
+	for i, stateID := range g.states {
+		position[stateID] = i
+	}
+	var stack []StateIdentifier
+	var components [][]StateIdentifier
+
+	var connect func(stateID StateIdentifier)
+	connect = func(stateID StateIdentifier) {
+		index[stateID] = len(index)
+		lowLink[stateID] = index[stateID]
+		stack = append(stack, stateID)
+		onStack[stateID] = true
+
+		for _, nextID := range g.edges[stateID] {
+			if _, visited := index[nextID]; !visited {
+				connect(nextID)
+				lowLink[stateID] = min(lowLink[stateID], lowLink[nextID])
+			} else if onStack[nextID] {
+				lowLink[stateID] = min(lowLink[stateID], index[nextID])
+			}
+		}
+
+		if lowLink[stateID] != index[stateID] {
+			return
+		}
+		var component []StateIdentifier
+		for {
+			topID := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[topID] = false
+			component = append(component, topID)
+			if topID == stateID {
+				break
+			}
+		}
+		slices.SortFunc(component, func(a, b StateIdentifier) int {
+			return position[a] - position[b]
+		})
+		components = append(components, component)
+	}
+
+	for _, stateID := range g.states {
+		if _, visited := index[stateID]; !visited {
+			connect(stateID)
+		}
+	}
+	return components
+}
+
+func (g *Graph[StateIdentifier]) reachableFrom(from StateIdentifier) map[StateIdentifier]bool {
+	reached := map[StateIdentifier]bool{}
+	if _, ok := g.edges[from]; !ok {
+		return reached
+	}
+	reached[from] = true
+	queue := []StateIdentifier{from}
+	for len(queue) > 0 {
+		stateID := queue[0]
+		queue = queue[1:]
+		for _, nextID := range g.edges[stateID] {
+			if !reached[nextID] {
+				reached[nextID] = true
+				queue = append(queue, nextID)
+			}
+		}
+	}
+	return reached
+}
@@ -0,0 +1,128 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/astavonin/gfsm"
+	"github.com/astavonin/gfsm/analysis"
+	"github.com/stretchr/testify/assert"
+)
+
+type uploadSM int
+
+const (
+	Idle uploadSM = iota
+	Active
+	Hashing
+	Uploading
+	Verifying
+	Paused
+	Done
+	Broken
+	Orphan
+)
+
+type noopState struct{}
+
+func (s *noopState) OnEnter(_ gfsm.StateMachineContext) {
+}
+
+func (s *noopState) OnExit(_ gfsm.StateMachineContext) {
+}
+
+func (s *noopState) Execute(_ gfsm.StateMachineContext, _ gfsm.EventContext) uploadSM {
+	return Idle
+}
+
+func newUploadBuilder() gfsm.StateMachineBuilder[uploadSM] {
+	return gfsm.NewBuilder[uploadSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &noopState{}, []uploadSM{Active}).
+		RegisterState(Active, &noopState{}, []uploadSM{Paused}).
+		RegisterSubState(Active, Hashing, &noopState{}, []uploadSM{Uploading}).
+		RegisterSubState(Active, Uploading, &noopState{}, []uploadSM{Verifying}).
+		RegisterSubState(Active, Verifying, &noopState{}, []uploadSM{Done, Broken}).
+		SetDefaultSubState(Active, Hashing).
+		RegisterState(Paused, &noopState{}, []uploadSM{Active}).
+		RegisterState(Done, &noopState{}, nil).
+		SetFinalState(Done).
+		RegisterState(Broken, &noopState{}, nil).
+		RegisterState(Orphan, &noopState{}, []uploadSM{Idle})
+}
+
+func TestGraph(t *testing.T) {
+	g := analysis.New(newUploadBuilder().Describe())
+
+	path, ok := g.ShortestPath(Idle, Verifying)
+	assert.True(t, ok)
+	assert.Equal(t, []uploadSM{Idle, Active, Hashing, Uploading, Verifying}, path)
+
+	// Paused is reachable from any sub-state through the parent's transition
+	path, ok = g.ShortestPath(Uploading, Paused)
+	assert.True(t, ok)
+	assert.Equal(t, []uploadSM{Uploading, Paused}, path)
+
+	assert.True(t, g.Reachable(Done, Done))
+	assert.False(t, g.Reachable(Done, Idle))
+	assert.False(t, g.Reachable(Idle, Orphan))
+	_, ok = g.ShortestPath(Idle, Orphan)
+	assert.False(t, ok)
+
+	assert.Equal(t, []uploadSM{Orphan}, g.Unreachable())
+	assert.Equal(t, []uploadSM{Broken}, g.DeadEnds())
+	assert.Equal(t, [][]uploadSM{
+		{Done},
+		{Broken},
+		{Active, Hashing, Uploading, Verifying, Paused},
+		{Idle},
+		{Orphan},
+	}, g.StronglyConnectedComponents())
+}
+
+func TestGraphSubStateWithoutWayOut(t *testing.T) {
+	g := analysis.New(gfsm.NewBuilder[uploadSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &noopState{}, []uploadSM{Active}).
+		RegisterState(Active, &noopState{}, nil).
+		RegisterSubState(Active, Hashing, &noopState{}, []uploadSM{Uploading}).
+		RegisterSubState(Active, Uploading, &noopState{}, nil).
+		SetDefaultSubState(Active, Hashing).
+		SetFinalState(Uploading).
+		Describe())
+
+	// the parent is active together with the sub-state, it is not entered again
+	assert.False(t, g.Reachable(Uploading, Hashing))
+	assert.False(t, g.Reachable(Uploading, Active))
+	path, ok := g.ShortestPath(Idle, Uploading)
+	assert.True(t, ok)
+	assert.Equal(t, []uploadSM{Idle, Active, Hashing, Uploading}, path)
+	assert.Equal(t, [][]uploadSM{
+		{Uploading},
+		{Hashing},
+		{Active},
+		{Idle},
+	}, g.StronglyConnectedComponents())
+}
+
+func TestGraphUnreachableDefaultSubState(t *testing.T) {
+	g := analysis.New(gfsm.NewBuilder[uploadSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &noopState{}, []uploadSM{Uploading}).
+		RegisterState(Active, &noopState{}, []uploadSM{Idle}).
+		RegisterSubState(Active, Hashing, &noopState{}, []uploadSM{Uploading}).
+		RegisterSubState(Active, Uploading, &noopState{}, nil).
+		SetDefaultSubState(Active, Hashing).
+		Describe())
+
+	// Active is active together with Uploading, but only a transition to Active enters Hashing
+	assert.Equal(t, []uploadSM{Hashing}, g.Unreachable())
+	assert.True(t, g.Reachable(Uploading, Idle))
+}
+
+func TestGraphOfBuiltMachine(t *testing.T) {
+	sm := newUploadBuilder().Build()
+	g := analysis.New(sm.Describe())
+
+	assert.Equal(t, []uploadSM{Orphan}, g.Unreachable())
+	assert.True(t, g.Reachable(Paused, Done))
+}
@@ -0,0 +1,161 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type OrderSM int
+
+const (
+	Received OrderSM = iota
+	Express
+	Standard
+	Manual
+	Route
+	Gate
+)
+
+type orderEvent struct {
+	amount int
+}
+
+func newOrderBuilder(journal *[]string, orders *int) StateMachineBuilder[OrderSM] {
+	state := func(id OrderSM, routes map[connEvent]OrderSM) *recordingState[OrderSM] {
+		return &recordingState[OrderSM]{id: id, journal: journal, routes: routes}
+	}
+	back := map[connEvent]OrderSM{"next": Received}
+	countOrder := func(smCtx StateMachineContext, _ EventContext) {
+		*smCtx.(*int)++
+	}
+	isLarge := func(_ StateMachineContext, eventCtx EventContext) bool {
+		return eventCtx.(orderEvent).amount > 100
+	}
+	isFirst := func(smCtx StateMachineContext, _ EventContext) bool {
+		return *smCtx.(*int) == 1
+	}
+	hasOrders := func(smCtx StateMachineContext, _ EventContext) bool {
+		return *smCtx.(*int) > 0
+	}
+	return NewBuilder[OrderSM]().
+		SetDefaultState(Received).
+		SetSmContext(orders).
+		RegisterState(Received, state(Received, map[connEvent]OrderSM{"ship": Gate}), []OrderSM{Gate, Express, Standard, Manual}).
+		RegisterState(Express, state(Express, back), []OrderSM{Received}).
+		RegisterState(Standard, state(Standard, back), []OrderSM{Received}).
+		RegisterState(Manual, state(Manual, back), []OrderSM{Received}).
+		AddTransition(Received, orderEvent{}, nil, Route, countOrder).
+		RegisterChoiceState(Route).
+		AddBranch(Route, isLarge, Manual).
+		AddBranch(Route, isFirst, Express).
+		AddBranch(Route, nil, Standard).
+		RegisterJunctionState(Gate).
+		AddBranch(Gate, hasOrders, Standard)
+}
+
+func TestChoiceState(t *testing.T) {
+	var journal []string
+	orders := 0
+	sm, err := newOrderBuilder(&journal, &orders).BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	// guards see the context updated by the transition action
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(orderEvent{amount: 10}))
+	assert.Equal(t, Express, sm.State())
+	assert.Equal(t, []string{"exit 0", "enter 1"}, journal)
+
+	for _, tc := range []struct {
+		amount int
+		state  OrderSM
+	}{
+		{amount: 10, state: Standard},
+		{amount: 500, state: Manual},
+	} {
+		assert.NoError(t, sm.ProcessEvent(connEvent("next")))
+		assert.NoError(t, sm.ProcessEvent(orderEvent{amount: tc.amount}))
+		assert.Equal(t, tc.state, sm.State())
+	}
+	assert.Equal(t, 3, orders)
+}
+
+func TestJunctionState(t *testing.T) {
+	var journal []string
+	orders := 0
+	sm := newOrderBuilder(&journal, &orders).Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	// no branch is taken, and the source state is not exited
+	journal = nil
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("ship")), ErrNoValidTransition)
+	assert.Equal(t, Received, sm.State())
+	assert.Equal(t, []string{"execute 0"}, journal)
+
+	orders = 1
+	assert.NoError(t, sm.ProcessEvent(connEvent("ship")))
+	assert.Equal(t, Standard, sm.State())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("next")))
+	assert.NoError(t, sm.(*stateMachine[OrderSM, EventContext, StateMachineContext]).ChangeState(Gate))
+	assert.Equal(t, Standard, sm.State())
+	assert.ErrorIs(t, sm.(*stateMachine[OrderSM, EventContext, StateMachineContext]).ChangeState(Route), ErrNoValidTransition)
+}
+
+func TestChoiceValidation(t *testing.T) {
+	var journal []string
+	orders := 0
+	isLarge := func(_ StateMachineContext, _ EventContext) bool { return true }
+
+	_, err := newOrderBuilder(&journal, &orders).
+		RegisterChoiceState(100).
+		AddBranch(100, isLarge, Manual).
+		BuildE()
+	assert.ErrorIs(t, err, ErrInvalidPseudoState)
+
+	_, err = newOrderBuilder(&journal, &orders).
+		RegisterJunctionState(100).
+		AddBranch(100, isLarge, 101).
+		RegisterJunctionState(101).
+		AddBranch(101, nil, 100).
+		BuildE()
+	assert.ErrorIs(t, err, ErrInvalidPseudoState)
+
+	_, err = newOrderBuilder(&journal, &orders).
+		AddBranch(Route, nil, 100).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+
+	// the source must allow every branch target
+	isSmall := func(_ StateMachineContext, _ EventContext) bool { return false }
+	narrow := NewBuilder[OrderSM]().
+		SetDefaultState(Received).
+		RegisterState(Received, &recordingState[OrderSM]{id: Received, journal: &journal}, []OrderSM{Express}).
+		RegisterState(Express, &recordingState[OrderSM]{id: Express, journal: &journal}, []OrderSM{Received}).
+		RegisterState(Manual, &recordingState[OrderSM]{id: Manual, journal: &journal}, []OrderSM{Received}).
+		AddTransition(Received, orderEvent{}, nil, Route, nil).
+		RegisterChoiceState(Route).
+		AddBranch(Route, isSmall, Express).
+		AddBranch(Route, nil, Manual)
+	_, err = narrow.BuildE()
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	sm := narrow.Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	journal = nil
+	err = sm.ProcessEvent(orderEvent{amount: 10})
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+	assert.ErrorIs(t, err, ErrNoValidTransition)
+	assert.Equal(t, Received, sm.State())
+	assert.Empty(t, journal)
+
+	desc := newOrderBuilder(&journal, &orders).Describe()
+	assert.Equal(t, []PseudoStateDescription[OrderSM]{
+		{ID: Route, Kind: Choice, Transitions: []OrderSM{Manual, Express, Standard}},
+		{ID: Gate, Kind: Junction, Transitions: []OrderSM{Standard}},
+	}, desc.PseudoStates)
+}
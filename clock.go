@@ -0,0 +1,127 @@
+package gfsm
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of state timeouts set with StateMachineBuilder.SetStateTimeout.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d duration, unless the returned timer is stopped.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending Clock.AfterFunc call.
+type Timer interface {
+	// Stop prevents the timer from firing, it returns false if the timer has already fired or been stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock using the time package, it is the default one.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is the Clock for tests, its time changes only on Advance calls, and timers fire synchronously from
+// Advance. Advance must not be called from state callbacks or listeners, as the fired timers process their events
+// under the state machine lock.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// seq keeps the creation order of timers firing at the same time.
+	seq int
+}
+
+// NewFakeClock creates FakeClock showing now time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	seq   int
+	f     func()
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &fakeTimer{clock: c, when: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires all the timers due by the new time, in order of their firing time.
+// Timers created by the fired callbacks also fire if they are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	for {
+		t := c.nextDue(until)
+		if t == nil {
+			break
+		}
+		c.now = t.when
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = until
+	c.mu.Unlock()
+}
+
+// PendingTimers returns the number of timers which have neither fired nor been stopped.
+func (c *FakeClock) PendingTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// nextDue removes and returns the earliest timer due by until, or nil if there is no such timer.
+func (c *FakeClock) nextDue(until time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, t := range c.timers {
+		if t.when.After(until) {
+			continue
+		}
+		if next == nil || t.when.Before(next.when) || (t.when.Equal(next.when) && t.seq < next.seq) {
+			next = t
+		}
+	}
+	if next != nil {
+		c.remove(next)
+	}
+	return next
+}
+
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	i := slices.Index(c.timers, t)
+	if i < 0 {
+		return false
+	}
+	c.timers = slices.Delete(c.timers, i, i+1)
+	return true
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
@@ -7,9 +7,11 @@
 //
 // This generator reads the file specified by the GOFILE environment variable,
 // then for each state machine builder chain (identified by a terminating Build()
-// call), it extracts the SM name from a SetSMName call and collects all
-// RegisterState transitions. It then writes a diagram for each state machine
-// into a separate file.
+// call), it extracts the SM name from a SetSMName call and collects the states
+// and transitions of the chain. It then writes a diagram for each state machine
+// into a separate file. Besides RegisterState transitions, the diagram shows
+// sub-states, parallel states, declarative transitions, choice and junction
+// branches, final states, external self-transitions and internal transitions.
 package main
 
 import (
@@ -25,21 +27,56 @@ import (
 )
 
 const (
-	setSMNameCall     = "SetSMName"
-	registerStateCall = "RegisterState"
-	buildCall         = "Build"
+	setSMNameCall          = "SetSMName"
+	registerStateCall      = "RegisterState"
+	registerSubStateCall   = "RegisterSubState"
+	setDefaultSubStateCall = "SetDefaultSubState"
+	setParallelStateCall   = "SetParallelState"
+	addTransitionCall      = "AddTransition"
+	setFinalStateCall      = "SetFinalState"
+	setCompletionCall      = "SetCompletionTransition"
+	registerChoiceCall     = "RegisterChoiceState"
+	registerJunctionCall   = "RegisterJunctionState"
+	addBranchCall          = "AddBranch"
+	addInternalCall        = "AddInternalTransition"
+	setSelfTransitionCall  = "SetSelfTransition"
 )
 
+// buildCalls terminate a builder chain.
+var buildCalls = map[string]bool{
+	"Build":           true,
+	"BuildE":          true,
+	"BuildDefinition": true,
+	"Restore":         true,
+	"Replay":          true,
+}
+
 // Transition represents a state transition.
 type Transition struct {
 	Source       string
+	Parent       string
 	Destinations []string
 }
 
+// GuardedTransition represents a declarative transition with its "event [guard] / action" label.
+type GuardedTransition struct {
+	Source      string
+	Destination string
+	Label       string
+}
+
 // StateMachine holds the name and all transitions for a state machine.
 type StateMachine struct {
-	Name        string
-	Transitions []Transition
+	Name             string
+	Transitions      []Transition
+	Guarded          []GuardedTransition
+	DefaultSubStates map[string]string
+	ParallelStates   map[string]bool
+	FinalStates      []string
+	// ChoiceStates are choice and junction pseudo-states, both are rendered as diamonds.
+	ChoiceStates []string
+	// Internal are internal transitions, Destination is not used.
+	Internal []GuardedTransition
 }
 
 func main() {
@@ -78,9 +115,9 @@ func doParse(filename string) (map[string]StateMachine, error) {
 			return true
 		}
 
-		// Look for calls to Build() which terminate a builder chain.
+		// Look for calls to Build() and its variants which terminate a builder chain.
 		sel, ok := call.Fun.(*ast.SelectorExpr)
-		if !ok || sel.Sel.Name != buildCall {
+		if !ok || !buildCalls[sel.Sel.Name] {
 			return true
 		}
 
@@ -88,21 +125,28 @@ func doParse(filename string) (map[string]StateMachine, error) {
 		chain := extractCallChain(call)
 
 		// Look for the custom naming function and register state calls.
-		smName, transitions := processChain(chain)
-		if smName == "" {
+		parsed := processChain(chain)
+		if parsed.Name == "" {
 			// If no SM name is provided, you might skip or assign a default name.
-			smName = "Unnamed"
+			parsed.Name = "Unnamed"
 		}
 
 		// Merge with any previously discovered machine of the same name.
-		if existing, ok := machines[smName]; ok {
-			existing.Transitions = append(existing.Transitions, transitions...)
-			machines[smName] = existing
-		} else {
-			machines[smName] = StateMachine{
-				Name:        smName,
-				Transitions: transitions,
+		if existing, ok := machines[parsed.Name]; ok {
+			existing.Transitions = append(existing.Transitions, parsed.Transitions...)
+			existing.Guarded = append(existing.Guarded, parsed.Guarded...)
+			existing.FinalStates = append(existing.FinalStates, parsed.FinalStates...)
+			existing.ChoiceStates = append(existing.ChoiceStates, parsed.ChoiceStates...)
+			existing.Internal = append(existing.Internal, parsed.Internal...)
+			for parent, subState := range parsed.DefaultSubStates {
+				existing.DefaultSubStates[parent] = subState
+			}
+			for parallel := range parsed.ParallelStates {
+				existing.ParallelStates[parallel] = true
 			}
+			machines[parsed.Name] = existing
+		} else {
+			machines[parsed.Name] = parsed
 		}
 
 		return true
@@ -184,11 +228,13 @@ func extractCallChain(expr ast.Expr) []*ast.CallExpr {
 	return chain
 }
 
-// processChain looks through the call chain for a SetSMName call and RegisterState calls.
-// It returns the SM name (from SetSMName) and a slice of transitions.
-func processChain(chain []*ast.CallExpr) (string, []Transition) {
-	var smName string
-	var transitions []Transition
+// processChain looks through the call chain for the SetSMName call and the calls describing states and transitions.
+// It returns the state machine with the SM name (from SetSMName) and all transitions.
+func processChain(chain []*ast.CallExpr) StateMachine {
+	sm := StateMachine{
+		DefaultSubStates: map[string]string{},
+		ParallelStates:   map[string]bool{},
+	}
 
 	// Iterate over each call in the chain.
 	for _, callExpr := range chain {
@@ -203,39 +249,215 @@ func processChain(chain []*ast.CallExpr) (string, []Transition) {
 			// Expect a single argument: a string literal with the SM name.
 			if len(callExpr.Args) >= 1 {
 				if lit, ok := callExpr.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
-					smName = strings.Trim(lit.Value, `"`)
+					sm.Name = strings.Trim(lit.Value, `"`)
 				}
 			}
 		case registerStateCall:
 			// Expect: RegisterState(source, stateInstance, []SM{dest1, dest2, ...})
+			if transition, ok := parseTransition(callExpr.Args); ok {
+				sm.Transitions = append(sm.Transitions, transition)
+			}
+		case registerSubStateCall:
+			// Expect: RegisterSubState(parent, source, stateInstance, []SM{dest1, dest2, ...})
+			if len(callExpr.Args) < 4 {
+				continue
+			}
+			parentIdent, ok := callExpr.Args[0].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if transition, ok := parseTransition(callExpr.Args[1:]); ok {
+				transition.Parent = parentIdent.Name
+				sm.Transitions = append(sm.Transitions, transition)
+			}
+		case setDefaultSubStateCall:
+			// Expect: SetDefaultSubState(parent, subState)
+			if len(callExpr.Args) < 2 {
+				continue
+			}
+			parentIdent, ok := callExpr.Args[0].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			subStateIdent, ok := callExpr.Args[1].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			sm.DefaultSubStates[parentIdent.Name] = subStateIdent.Name
+		case setParallelStateCall:
+			// Expect: SetParallelState(state)
+			if len(callExpr.Args) < 1 {
+				continue
+			}
+			if ident, ok := callExpr.Args[0].(*ast.Ident); ok {
+				sm.ParallelStates[ident.Name] = true
+			}
+		case addTransitionCall:
+			// Expect: AddTransition(source, event, guard, destination, action)
+			if len(callExpr.Args) < 5 {
+				continue
+			}
+			srcIdent, ok := callExpr.Args[0].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			dstIdent, ok := callExpr.Args[3].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			sm.Guarded = append(sm.Guarded, GuardedTransition{
+				Source:      srcIdent.Name,
+				Destination: dstIdent.Name,
+				Label:       transitionLabel(callExpr.Args[1], callExpr.Args[2], callExpr.Args[4]),
+			})
+		case setFinalStateCall:
+			// Expect: SetFinalState(state)
+			if len(callExpr.Args) < 1 {
+				continue
+			}
+			if ident, ok := callExpr.Args[0].(*ast.Ident); ok {
+				sm.FinalStates = append(sm.FinalStates, ident.Name)
+			}
+		case setCompletionCall:
+			// Expect: SetCompletionTransition(source, destination)
+			if len(callExpr.Args) < 2 {
+				continue
+			}
+			srcIdent, ok := callExpr.Args[0].(*ast.Ident)
+			if !ok {
+				continue
+			}
+			if dstIdent, ok := callExpr.Args[1].(*ast.Ident); ok {
+				sm.Guarded = append(sm.Guarded, GuardedTransition{Source: srcIdent.Name, Destination: dstIdent.Name})
+			}
+		case registerChoiceCall, registerJunctionCall:
+			// Expect: RegisterChoiceState(choice) or RegisterJunctionState(junction)
+			if len(callExpr.Args) < 1 {
+				continue
+			}
+			if ident, ok := callExpr.Args[0].(*ast.Ident); ok {
+				sm.ChoiceStates = append(sm.ChoiceStates, ident.Name)
+			}
+		case addBranchCall:
+			// Expect: AddBranch(choice, guard, destination)
 			if len(callExpr.Args) < 3 {
 				continue
 			}
-			// First argument: source state (identifier).
 			srcIdent, ok := callExpr.Args[0].(*ast.Ident)
 			if !ok {
 				continue
 			}
-			source := srcIdent.Name
-
-			// Third argument: allowed transitions as a composite literal.
-			compLit, ok := callExpr.Args[2].(*ast.CompositeLit)
+			dstIdent, ok := callExpr.Args[2].(*ast.Ident)
 			if !ok {
 				continue
 			}
-			var dests []string
-			for _, elt := range compLit.Elts {
-				if ident, ok := elt.(*ast.Ident); ok {
-					dests = append(dests, ident.Name)
-				}
+			label := "[else]"
+			if name := exprName(callExpr.Args[1]); name != "" {
+				label = "[" + name + "]"
+			} else if ident, ok := callExpr.Args[1].(*ast.Ident); !ok || ident.Name != "nil" {
+				// function literals have no name
+				label = ""
+			}
+			sm.Guarded = append(sm.Guarded, GuardedTransition{
+				Source:      srcIdent.Name,
+				Destination: dstIdent.Name,
+				Label:       label,
+			})
+		case addInternalCall:
+			// Expect: AddInternalTransition(source, event, guard, action)
+			if len(callExpr.Args) < 4 {
+				continue
+			}
+			srcIdent, ok := callExpr.Args[0].(*ast.Ident)
+			if !ok {
+				continue
 			}
-			transitions = append(transitions, Transition{
-				Source:       source,
-				Destinations: dests,
+			sm.Internal = append(sm.Internal, GuardedTransition{
+				Source: srcIdent.Name,
+				Label:  transitionLabel(callExpr.Args[1], callExpr.Args[2], callExpr.Args[3]),
 			})
+		case setSelfTransitionCall:
+			// Expect: SetSelfTransition(state, ExternalTransition)
+			if len(callExpr.Args) < 2 || exprName(callExpr.Args[1]) != "ExternalTransition" {
+				continue
+			}
+			if ident, ok := callExpr.Args[0].(*ast.Ident); ok {
+				sm.Guarded = append(sm.Guarded, GuardedTransition{Source: ident.Name, Destination: ident.Name})
+			}
 		}
 	}
-	return smName, transitions
+	return sm
+}
+
+// parseTransition extracts a transition from (source, stateInstance, []SM{dest1, dest2, ...}) arguments.
+func parseTransition(args []ast.Expr) (Transition, bool) {
+	if len(args) < 3 {
+		return Transition{}, false
+	}
+	// First argument: source state (identifier).
+	srcIdent, ok := args[0].(*ast.Ident)
+	if !ok {
+		return Transition{}, false
+	}
+
+	// Third argument: allowed transitions as a composite literal, or nil for states without own transitions.
+	var dests []string
+	switch arg := args[2].(type) {
+	case *ast.CompositeLit:
+		for _, elt := range arg.Elts {
+			if ident, ok := elt.(*ast.Ident); ok {
+				dests = append(dests, ident.Name)
+			}
+		}
+	case *ast.Ident:
+		if arg.Name != "nil" {
+			return Transition{}, false
+		}
+	default:
+		return Transition{}, false
+	}
+	return Transition{
+		Source:       srcIdent.Name,
+		Destinations: dests,
+	}, true
+}
+
+// transitionLabel builds UML "event [guard] / action" label, omitting missing parts.
+func transitionLabel(event, guard, action ast.Expr) string {
+	var parts []string
+	if name := exprName(event); name != "" {
+		parts = append(parts, name)
+	}
+	if name := exprName(guard); name != "" {
+		parts = append(parts, "["+name+"]")
+	}
+	if name := exprName(action); name != "" {
+		parts = append(parts, "/ "+name)
+	}
+	return strings.Join(parts, " ")
+}
+
+// exprName returns a readable name of an event sample (commitRequest{}, connEvent("drop")) or a function reference
+// (hasVotes, pkg.hasVotes). Function literals and nil have no name.
+func exprName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Name == "nil" {
+			return ""
+		}
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.CompositeLit:
+		return exprName(e.Type)
+	case *ast.CallExpr:
+		return exprName(e.Fun)
+	case *ast.UnaryExpr:
+		return exprName(e.X)
+	case *ast.IndexExpr:
+		return exprName(e.X)
+	}
+	return ""
 }
 
 // buildMermaid generates a Mermaid state diagram for the state machine.
@@ -243,11 +465,7 @@ func buildMermaid(sm StateMachine) string {
 	var b strings.Builder
 	b.WriteString("```mermaid\n")
 	b.WriteString("stateDiagram-v2\n")
-	for _, t := range sm.Transitions {
-		for _, dest := range t.Destinations {
-			b.WriteString(fmt.Sprintf("    %s --> %s\n", t.Source, dest))
-		}
-	}
+	writeStates(&b, sm, "", "    ", "    ")
 	b.WriteString("```\n")
 	return b.String()
 }
@@ -256,11 +474,84 @@ func buildMermaid(sm StateMachine) string {
 func buildPlantUML(sm StateMachine) string {
 	var b strings.Builder
 	b.WriteString("@startuml\n")
+	writeStates(&b, sm, "", "", "    ")
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// writeStates writes the states of the composite state parent (or the top level states if parent is empty).
+// Composite states are rendered as nested blocks, regions of parallel states are separated with "--", transitions
+// between siblings are placed into the block of their parent and all other transitions are placed on the top level.
+// Mermaid and PlantUML share this syntax.
+func writeStates(b *strings.Builder, sm StateMachine, parent string, indent string, step string) {
+	parents := map[string]string{}
+	var children []string
+	for _, t := range sm.Transitions {
+		parents[t.Source] = t.Parent
+		if t.Parent == parent {
+			children = append(children, t.Source)
+		}
+	}
+
+	if parent == "" {
+		for _, choice := range sm.ChoiceStates {
+			b.WriteString(fmt.Sprintf("%sstate %s <<choice>>\n", indent, choice))
+		}
+	}
+
+	blocks := 0
+	for _, child := range children {
+		subState, ok := sm.DefaultSubStates[child]
+		if !ok && !sm.ParallelStates[child] {
+			continue
+		}
+		if sm.ParallelStates[parent] && blocks > 0 {
+			b.WriteString(fmt.Sprintf("%s--\n", indent))
+		}
+		blocks++
+		b.WriteString(fmt.Sprintf("%sstate %s {\n", indent, child))
+		if ok {
+			b.WriteString(fmt.Sprintf("%s%s[*] --> %s\n", indent, step, subState))
+		}
+		writeStates(b, sm, child, indent+step, step)
+		b.WriteString(fmt.Sprintf("%s}\n", indent))
+	}
+
+	// whether the transition belongs to the current block
+	inBlock := func(source, dest string) bool {
+		inner := parents[source] != "" && parents[dest] == parents[source]
+		return (parent == "" && !inner) || (parent != "" && inner && parents[source] == parent)
+	}
+	labelled := map[[2]string]bool{}
+	for _, t := range sm.Guarded {
+		labelled[[2]string{t.Source, t.Destination}] = true
+	}
+
 	for _, t := range sm.Transitions {
 		for _, dest := range t.Destinations {
-			b.WriteString(fmt.Sprintf("%s --> %s\n", t.Source, dest))
+			if inBlock(t.Source, dest) && !labelled[[2]string{t.Source, dest}] {
+				b.WriteString(fmt.Sprintf("%s%s --> %s\n", indent, t.Source, dest))
+			}
+		}
+	}
+	for _, t := range sm.Guarded {
+		if !inBlock(t.Source, t.Destination) {
+			continue
+		}
+		if t.Label == "" {
+			b.WriteString(fmt.Sprintf("%s%s --> %s\n", indent, t.Source, t.Destination))
+		} else {
+			b.WriteString(fmt.Sprintf("%s%s --> %s : %s\n", indent, t.Source, t.Destination, t.Label))
+		}
+	}
+	for _, t := range sm.Internal {
+		if parents[t.Source] == parent && t.Label != "" {
+			b.WriteString(fmt.Sprintf("%s%s : %s\n", indent, t.Source, t.Label))
+		}
+	}
+	for _, final := range sm.FinalStates {
+		if parents[final] == parent {
+			b.WriteString(fmt.Sprintf("%s%s --> [*]\n", indent, final))
 		}
 	}
-	b.WriteString("@enduml\n")
-	return b.String()
 }
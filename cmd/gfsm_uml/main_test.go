@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const finalStatesSource = `package sample
+
+func build() {
+	gfsm.NewBuilder[Job]().
+		SetSMName("Job").
+		SetDefaultState(Running).
+		RegisterState(Running, &running{}, []Job{Done}).
+		RegisterState(Done, &done{}, nil).
+		SetFinalState(Done).
+		Build()
+
+	gfsm.NewBuilder[Job]().
+		SetSMName("Job").
+		RegisterState(Running, &running{}, []Job{Failed}).
+		RegisterState(Failed, &failed{}, nil).
+		SetFinalState(Failed).
+		Build()
+}
+`
+
+func TestParseFinalStates(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sample.go")
+	assert.NoError(t, os.WriteFile(filename, []byte(finalStatesSource), 0644))
+
+	machines, err := doParse(filename)
+	assert.NoError(t, err)
+	// the final states of both chains are merged into the same state machine
+	sm := machines["Job"]
+	assert.Equal(t, []string{"Done", "Failed"}, sm.FinalStates)
+	assert.Contains(t, buildPlantUML(sm), "Done --> [*]\nFailed --> [*]\n")
+}
+
+const selfTransitionsSource = `package sample
+
+func build() {
+	gfsm.NewBuilder[Job]().
+		SetSMName("Job").
+		SetDefaultState(Running).
+		RegisterState(Running, &running{}, nil).
+		SetSelfTransition(Running, gfsm.ExternalTransition).
+		AddInternalTransition(Running, progress{}, nil, report).
+		Build()
+}
+`
+
+func TestParseSelfTransitions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sample.go")
+	assert.NoError(t, os.WriteFile(filename, []byte(selfTransitionsSource), 0644))
+
+	machines, err := doParse(filename)
+	assert.NoError(t, err)
+	// the external self-transition is a loop, and the internal transition is listed inside the state
+	assert.Equal(t, "@startuml\nRunning --> Running\nRunning : progress / report\n@enduml\n", buildPlantUML(machines["Job"]))
+}
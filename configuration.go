@@ -0,0 +1,278 @@
+package gfsm
+
+import "context"
+
+// leafSet accumulates leaf states entered during a transition. The most common case of a single leaf is kept
+// without allocations.
+type leafSet[StateIdentifier comparable] struct {
+	first StateIdentifier
+	count int
+	// all contains every leaf in entering order, it is allocated only if there is more than one leaf
+	all []StateIdentifier
+}
+
+func (l *leafSet[StateIdentifier]) add(stateID StateIdentifier) {
+	switch l.count {
+	case 0:
+		l.first = stateID
+	case 1:
+		l.all = []StateIdentifier{l.first, stateID}
+	default:
+		l.all = append(l.all, stateID)
+	}
+	l.count++
+}
+
+// isAncestor reports whether ancestorID is a proper ancestor of stateID.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) isAncestor(ancestorID, stateID StateIdentifier) bool {
+	for parentID, ok := s.parentOf(stateID); ok; parentID, ok = s.parentOf(parentID) {
+		if parentID == ancestorID {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionDomain returns the innermost state that is a proper ancestor of both states and is not a parallel one,
+// which means that the transition does not leave it. The second return value is false if there is no such state,
+// and the transition crosses the root. b can be a choice pseudo-state, which is enclosed by the states enclosing all
+// its targets.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) transitionDomain(a, b StateIdentifier) (StateIdentifier, bool) {
+	for parentID, ok := s.parentOf(a); ok; parentID, ok = s.parentOf(parentID) {
+		if s.encloses(parentID, b) && !s.states[parentID].parallel {
+			return parentID, true
+		}
+	}
+	var none StateIdentifier
+	return none, false
+}
+
+// isActive reports whether stateID is one of the active states or their ancestors.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) isActive(stateID StateIdentifier) bool {
+	if s.activeLeaves == nil {
+		return s.currentStateID == stateID || s.isAncestor(stateID, s.currentStateID)
+	}
+	for _, leafID := range s.activeLeaves {
+		if leafID == stateID || s.isAncestor(stateID, leafID) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeChildOf returns the active sub-state of the active composite (not parallel) state parentID.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) activeChildOf(parentID StateIdentifier) StateIdentifier {
+	leaves := s.activeLeaves
+	if leaves == nil {
+		leaves = []StateIdentifier{s.currentStateID}
+	}
+	for _, leafID := range leaves {
+		childID := leafID
+		for stateID, ok := s.parentOf(childID); ok; stateID, ok = s.parentOf(childID) {
+			if stateID == parentID {
+				return childID
+			}
+			childID = stateID
+		}
+	}
+	var none StateIdentifier
+	return none
+}
+
+// setCurrent makes stateID the one State returns while the target states of a transition are entered.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) setCurrent(stateID StateIdentifier) {
+	s.lockState()
+	defer s.unlockState()
+	s.currentStateID = stateID
+}
+
+// setConfiguration makes the entered leaves the active ones.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) setConfiguration(leaves leafSet[StateIdentifier]) {
+	s.lockState()
+	defer s.unlockState()
+
+	if leaves.count == 1 {
+		s.currentStateID = leaves.first
+		s.activeLeaves = nil
+		return
+	}
+
+	s.activeLeaves = leaves.all
+	// the innermost state containing all the leaves
+	s.currentStateID = leaves.first
+	for _, leafID := range leaves.all {
+		for s.currentStateID != leafID && !s.isAncestor(s.currentStateID, leafID) {
+			s.currentStateID, _ = s.parentOf(s.currentStateID)
+		}
+	}
+}
+
+// mergeLeaves replaces leaves located under domainID with the entered ones, keeping the regions order.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) mergeLeaves(
+	leaves []StateIdentifier,
+	domainID StateIdentifier,
+	entered leafSet[StateIdentifier]) leafSet[StateIdentifier] {
+
+	merged := leafSet[StateIdentifier]{}
+	inserted := false
+	for _, leafID := range leaves {
+		if !s.isAncestor(domainID, leafID) {
+			merged.add(leafID)
+			continue
+		}
+		if inserted {
+			continue
+		}
+		inserted = true
+		if entered.all == nil {
+			merged.add(entered.first)
+			continue
+		}
+		for _, enteredID := range entered.all {
+			merged.add(enteredID)
+		}
+	}
+	return merged
+}
+
+// exit calls OnExit for all active states below untilID: leafID and its ancestors, or, with orthogonal regions
+// active, each of the leaves with their ancestors. Regions are exited in reverse registration order, and each state
+// is exited after all its active sub-states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) exit(
+	ctx context.Context,
+	leafID StateIdentifier,
+	leaves []StateIdentifier,
+	untilID StateIdentifier,
+	hasUntil bool) {
+
+	if leaves == nil {
+		s.exitBranch(ctx, leafID, untilID, hasUntil, untilID, false)
+		return
+	}
+	for i := len(leaves) - 1; i >= 0; i-- {
+		if hasUntil && !s.isAncestor(untilID, leaves[i]) {
+			continue
+		}
+		// ancestors shared with the previous region are exited together with it
+		var sharedID StateIdentifier
+		hasShared := false
+		if i > 0 {
+			sharedID, hasShared = s.commonAncestorOrSelf(leaves[i-1], leaves[i])
+		}
+		s.exitBranch(ctx, leaves[i], untilID, hasUntil, sharedID, hasShared)
+	}
+}
+
+// exitBranch calls OnExit for leafID and its ancestors, stopping at untilID or sharedID, whichever comes first.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) exitBranch(
+	ctx context.Context,
+	leafID StateIdentifier,
+	untilID StateIdentifier,
+	hasUntil bool,
+	sharedID StateIdentifier,
+	hasShared bool) {
+
+	for stateID, ok := leafID, true; ok; stateID, ok = s.parentOf(stateID) {
+		if (hasUntil && stateID == untilID) || (hasShared && stateID == sharedID) {
+			return
+		}
+		st := s.states[stateID]
+		s.stopTimer(stateID)
+		s.enterSite(stateID, PhaseOnExit)
+		st.onExit(ctx, s.smCtx)
+		s.leaveSite()
+		s.remember(stateID)
+		if s.recoverPanics {
+			x := s.extra()
+			x.exited = append(x.exited, stateID)
+		}
+	}
+}
+
+// commonAncestorOrSelf returns the innermost state containing both states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) commonAncestorOrSelf(a, b StateIdentifier) (StateIdentifier, bool) {
+	for stateID, ok := a, true; ok; stateID, ok = s.parentOf(stateID) {
+		if stateID == b || s.isAncestor(stateID, b) {
+			return stateID, true
+		}
+	}
+	var none StateIdentifier
+	return none, false
+}
+
+// enter calls OnEnter for all states from fromID (not included) down to targetID, and then enters default
+// sub-states of targetID until leaf states are reached. Parallel states met on the way enter all their regions.
+// The entered leaf states are added to leaves.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enter(
+	ctx context.Context,
+	fromID StateIdentifier,
+	hasFrom bool,
+	targetID StateIdentifier,
+	leaves *leafSet[StateIdentifier]) {
+
+	topID := targetID
+	for parentID, ok := s.parentOf(topID); ok && !(hasFrom && parentID == fromID); parentID, ok = s.parentOf(topID) {
+		topID = parentID
+	}
+	s.enterTowards(ctx, topID, targetID, leaves)
+}
+
+// enterTowards enters stateID, which is targetID or its ancestor, and continues down to targetID.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enterTowards(
+	ctx context.Context,
+	stateID, targetID StateIdentifier,
+	leaves *leafSet[StateIdentifier]) {
+
+	if stateID == targetID {
+		s.enterState(ctx, targetID, leaves)
+		return
+	}
+	st := s.states[stateID]
+	s.enterSite(stateID, PhaseOnEnter)
+	st.onEnter(ctx, s.smCtx)
+	s.leaveSite()
+	s.startTimer(stateID)
+	if !st.parallel {
+		s.enterTowards(ctx, s.childTowards(stateID, targetID), targetID, leaves)
+		return
+	}
+	for _, regionID := range st.regions {
+		if regionID == targetID || s.isAncestor(regionID, targetID) {
+			s.enterTowards(ctx, regionID, targetID, leaves)
+		} else {
+			s.enterState(ctx, regionID, leaves)
+		}
+	}
+}
+
+// enterState enters stateID and its default sub-states, or all the regions for parallel states. Sub-states saved
+// in history are entered instead of the default ones while a history pseudo-state is entered.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enterState(ctx context.Context, stateID StateIdentifier, leaves *leafSet[StateIdentifier]) {
+	for {
+		st := s.states[stateID]
+		s.enterSite(stateID, PhaseOnEnter)
+		st.onEnter(ctx, s.smCtx)
+		s.leaveSite()
+		s.startTimer(stateID)
+		switch {
+		case st.parallel:
+			for _, regionID := range st.regions {
+				s.enterState(ctx, regionID, leaves)
+			}
+			return
+		case st.composite:
+			stateID = s.subStateToEnter(stateID)
+		default:
+			leaves.add(stateID)
+			return
+		}
+	}
+}
+
+// childTowards returns the sub-state of ancestorID on the path to stateID.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) childTowards(ancestorID, stateID StateIdentifier) StateIdentifier {
+	for parentID, ok := s.parentOf(stateID); ok && parentID != ancestorID; parentID, ok = s.parentOf(stateID) {
+		stateID = parentID
+	}
+	return stateID
+}
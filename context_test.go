@@ -0,0 +1,105 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceKey struct{}
+
+// tracingState logs the trace ID found in the context of each callback. "wait" event blocks until ctx is done.
+type tracingState struct {
+	recordingState[ConnSM]
+}
+
+func (s *tracingState) OnEnterCtx(ctx context.Context, _ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("enter %v %v", s.id, ctx.Value(traceKey{})))
+}
+
+func (s *tracingState) OnExitCtx(ctx context.Context, _ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("exit %v %v", s.id, ctx.Value(traceKey{})))
+}
+
+func (s *tracingState) ExecuteCtx(ctx context.Context, _ StateMachineContext, eventCtx EventContext) (ConnSM, error) {
+	if eventCtx == connEvent("wait") {
+		<-ctx.Done()
+		return s.id, ctx.Err()
+	}
+	*s.journal = append(*s.journal, fmt.Sprintf("execute %v %v", s.id, ctx.Value(traceKey{})))
+	if next, ok := s.routes[eventCtx.(connEvent)]; ok {
+		return next, nil
+	}
+	return s.id, nil
+}
+
+func newTracingSM(journal *[]string) StateMachineHandler[ConnSM] {
+	return NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &tracingState{recordingState[ConnSM]{
+			id: Idle, journal: journal, routes: map[connEvent]ConnSM{"work": Busy}}}, []ConnSM{Busy}).
+		RegisterState(Busy, &tracingState{recordingState[ConnSM]{id: Busy, journal: journal}}, []ConnSM{Idle}).
+		Build()
+}
+
+func TestContextPropagation(t *testing.T) {
+	var journal []string
+	sm := newTracingSM(&journal)
+
+	sm.StartCtx(context.WithValue(context.Background(), traceKey{}, "start"))
+	assert.NoError(t, sm.ProcessEventCtx(context.WithValue(context.Background(), traceKey{}, "work"), connEvent("work")))
+	assert.Equal(t, []string{
+		"enter 2 start",
+		"execute 2 work",
+		"exit 2 work",
+		"enter 3 work",
+	}, journal)
+
+	journal = nil
+	sm.Stop()
+	assert.Equal(t, []string{"exit 3 <nil>"}, journal)
+}
+
+func TestContextCancellation(t *testing.T) {
+	var journal []string
+	sm := newTracingSM(&journal)
+	sm.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	journal = nil
+	assert.ErrorIs(t, sm.ProcessEventCtx(ctx, connEvent("work")), context.Canceled)
+	assert.Empty(t, journal)
+	assert.Equal(t, Idle, sm.State())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go cancel()
+	err := sm.ProcessEventCtx(ctx, connEvent("wait"))
+	assert.ErrorIs(t, err, context.Canceled)
+	var execErr *ExecuteError[ConnSM, EventContext]
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, Idle, execErr.State)
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestMailboxDiscardsCancelledEvents(t *testing.T) {
+	f := newMailboxFixture(4, OverflowBlock)
+	f.sm.Start()
+	defer f.sm.Stop()
+	f.blockLoop(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pending := make(chan error)
+	go func() {
+		pending <- f.sm.Send(ctx, connEvent("work"))
+	}()
+	f.waitQueue(t, 1)
+	cancel()
+	close(f.release)
+
+	assert.ErrorIs(t, <-pending, context.Canceled)
+	assert.NoError(t, f.sm.ProcessEvent(connEvent("last")))
+	assert.Equal(t, []string{"block", "last"}, f.events())
+}
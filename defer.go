@@ -0,0 +1,97 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// ErrDeferredOverflow is returned by ProcessEvent if the event is deferred by the active state, but the deferred
+// events queue already holds as many events as set with StateMachineBuilder.SetDeferLimit. The event is dropped.
+var ErrDeferredOverflow = fmt.Errorf("deferred events queue is full")
+
+// DeferredEventError is returned by the call which made a transition (ProcessEvent or ChangeState) if processing of
+// a deferred event re-dispatched after the transition failed. State timeouts report it to TypedListener.TimeoutFailed. The transition itself has succeeded. The
+// failed event is dropped, and the events deferred after it stay queued until the next transition.
+type DeferredEventError[StateIdentifier comparable, Event any] struct {
+	// State is the state the event was re-dispatched in.
+	State StateIdentifier
+	// Event is the failed deferred event.
+	Event Event
+	// Err is the processing error.
+	Err error
+}
+
+func (e *DeferredEventError[StateIdentifier, Event]) Error() string {
+	return fmt.Sprintf("deferred event %v failed in state %v: %v", e.Event, e.State, e.Err)
+}
+
+func (e *DeferredEventError[StateIdentifier, Event]) Unwrap() error {
+	return e.Err
+}
+
+// DefaultDeferLimit is the deferred events queue capacity used unless StateMachineBuilder.SetDeferLimit is called.
+const DefaultDeferLimit = 64
+
+// defers reports whether any active state or its ancestor defers events of the same dynamic type as eventCtx.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) defers(eventCtx Event) bool {
+	if !s.hasDeferred {
+		return false
+	}
+	eventType := reflect.TypeOf(eventCtx)
+	leaves := s.activeLeaves
+	if leaves == nil {
+		leaves = []StateIdentifier{s.currentStateID}
+	}
+	for _, leafID := range leaves {
+		for stateID, ok := leafID, true; ok; stateID, ok = s.parentOf(stateID) {
+			if _, deferred := s.states[stateID].deferred[eventType]; deferred {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deferEvent queues the event until the next transition.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) deferEvent(eventCtx Event) error {
+	x := s.extra()
+	if len(x.deferred) >= s.deferLimit {
+		return fmt.Errorf("cannot defer event %v in %v: %w", eventCtx, s.currentStateID, ErrDeferredOverflow)
+	}
+	x.deferred = append(x.deferred, eventCtx)
+	return nil
+}
+
+// redispatch processes the deferred events after a transition. The queued events are passed to the active states in
+// the deferring order, and the events deferred again are queued once more. Another round starts if any of the events
+// made a transition, as the new states can accept the events deferred again. The first error stops the processing and
+// is returned as *DeferredEventError, the failed event is dropped and the rest of the events stay deferred.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) redispatch(ctx context.Context) error {
+	x := s.extras.Load()
+	if x == nil || x.redispatching {
+		// nothing is deferred, or the outer call continues with the events deferred again
+		return nil
+	}
+	x.redispatching = true
+	defer func() {
+		x.redispatching = false
+	}()
+
+	for len(x.deferred) > 0 {
+		transitions := x.transitions
+		pending := x.deferred
+		x.deferred = nil
+		for i, eventCtx := range pending {
+			stateID := s.currentStateID
+			if err := s.processEvent(ctx, eventCtx); err != nil {
+				x.deferred = append(x.deferred, pending[i+1:]...)
+				return &DeferredEventError[StateIdentifier, Event]{State: stateID, Event: eventCtx, Err: err}
+			}
+		}
+		if x.transitions == transitions {
+			break
+		}
+	}
+	return nil
+}
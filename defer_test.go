@@ -0,0 +1,128 @@
+package gfsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type taskEvent struct {
+	id int
+}
+
+func newTaskBuilder(journal *[]string) StateMachineBuilder[ConnSM] {
+	startTask := func(_ StateMachineContext, eventCtx EventContext) {
+		*journal = append(*journal, fmt.Sprintf("task %d", eventCtx.(taskEvent).id))
+	}
+	return newConnBuilder(journal).
+		AddTransition(Idle, taskEvent{}, nil, Busy, startTask).
+		DeferEvent(Busy, taskEvent{})
+}
+
+func TestDeferredEvents(t *testing.T) {
+	var journal []string
+	sm, err := newTaskBuilder(&journal).BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 1}))
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 2}))
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 3}))
+	assert.Equal(t, Busy, sm.State())
+	assert.Empty(t, journal)
+
+	// task 2 is started right after the transition to Idle, and task 3 is deferred again by Busy
+	assert.NoError(t, sm.ProcessEvent(connEvent("done")))
+	assert.Equal(t, Busy, sm.State())
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 2", "exit 2", "task 2", "enter 3"}, journal)
+
+	journal = nil
+	assert.NoError(t, sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).ChangeState(Idle))
+	assert.Equal(t, Busy, sm.State())
+	assert.Equal(t, []string{"exit 3", "enter 2", "exit 2", "task 3", "enter 3"}, journal)
+
+	// nothing is left to redispatch
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("done")))
+	assert.Equal(t, Idle, sm.State())
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 2"}, journal)
+}
+
+func TestDeferredEventsComposite(t *testing.T) {
+	var journal []string
+	sm := newTaskBuilder(&journal).
+		DeferEvent(Disconnected, taskEvent{}).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	// Idle accepts the task deferred by Disconnected once Connected is entered
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 1}))
+	assert.Equal(t, Disconnected, sm.State())
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, Busy, sm.State())
+
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 2}))
+	assert.NoError(t, sm.Reset())
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestDeferredEventsOverflow(t *testing.T) {
+	var journal []string
+	sm := newTaskBuilder(&journal).
+		SetDeferLimit(1).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 1}))
+
+	assert.NoError(t, sm.ProcessEvent(taskEvent{id: 2}))
+	assert.ErrorIs(t, sm.ProcessEvent(taskEvent{id: 3}), ErrDeferredOverflow)
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("done")))
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 2", "exit 2", "task 2", "enter 3"}, journal)
+
+	_, err := newTaskBuilder(&journal).
+		DeferEvent(100, taskEvent{}).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+}
+
+func TestDeferredEventError(t *testing.T) {
+	smCtx := &counterContext{limit: 5}
+	sm := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(Stop).
+		SetSmContext(smCtx).
+		RegisterState(Stop, &stoppedState{}, []StartStopSM{InProgress}).
+		RegisterState(InProgress, &limitedState{}, []StartStopSM{Stop}).
+		DeferEvent(Stop, counterEvent{}).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: -1}))
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 2}))
+
+	// the transition succeeds, while the first deferred event is rejected and dropped
+	typed := sm.(*stateMachine[StartStopSM, counterEvent, *counterContext])
+	err := typed.ChangeState(InProgress)
+	var deferredErr *DeferredEventError[StartStopSM, counterEvent]
+	if assert.ErrorAs(t, err, &deferredErr) {
+		assert.Equal(t, InProgress, deferredErr.State)
+		assert.Equal(t, counterEvent{delta: -1}, deferredErr.Event)
+	}
+	assert.ErrorIs(t, err, ErrEventRejected)
+	assert.Equal(t, InProgress, sm.State())
+	assert.Zero(t, smCtx.value)
+
+	// the rest is processed after the next transition
+	assert.NoError(t, typed.ChangeState(Stop))
+	assert.NoError(t, typed.ChangeState(InProgress))
+	assert.Equal(t, 2, smCtx.value)
+}
@@ -0,0 +1,113 @@
+package gfsm
+
+// definition is the immutable part of a state machine shared by all its instances.
+type definition[StateIdentifier comparable, Event any, SmContext any] struct {
+	states         TypedStatesMap[StateIdentifier, Event, SmContext]
+	defaultStateID StateIdentifier
+	name           string
+	// order keeps states registration order, it is nil for state machines created without the builder.
+	order []StateIdentifier
+
+	listeners   []*TypedListener[StateIdentifier, Event]
+	middlewares []TypedMiddleware[Event]
+	// codec is used to store the state machine context in snapshots, it is optional.
+	codec ContextCodec[SmContext]
+	// store is used to persist snapshots of the instances with an ID, it is optional.
+	store         Store[StateIdentifier]
+	persistPolicy PersistPolicy
+	// eventLog records the processed events of the instances, it is optional.
+	eventLog TypedEventLog[StateIdentifier, Event]
+
+	// clock drives state timeouts, hasTimeouts is set if any state has one.
+	clock       Clock
+	hasTimeouts bool
+
+	// pseudo are the pseudo-states, pseudoOrder keeps their registration order. hasHistory is set if any of them is
+	// a history one.
+	pseudo      map[StateIdentifier]pseudoState[StateIdentifier, Event, SmContext]
+	pseudoOrder []StateIdentifier
+	hasHistory  bool
+
+	// recoverPanics converts panics of the callbacks to PanicError. errorStateID is entered after a recovered
+	// panic, valid only if hasErrorState is set, otherwise the configuration is rolled back.
+	recoverPanics bool
+	errorStateID  StateIdentifier
+	hasErrorState bool
+
+	// deferLimit is the capacity of the deferred events queue, hasDeferred is set if any state defers events.
+	deferLimit  int
+	hasDeferred bool
+
+	threadSafe bool
+	// mailboxCapacity and mailboxPolicy are valid only if hasMailbox is set.
+	hasMailbox      bool
+	mailboxCapacity int
+	mailboxPolicy   OverflowPolicy
+}
+
+// TypedDefinition is a compiled state machine definition created by StateMachineBuilder.BuildDefinition. It is
+// immutable and safe for concurrent use, so a single definition can create any number of lightweight instances,
+// which share the states, transitions, listeners and middlewares, and keep only the active configuration and the
+// state machine context of their own.
+//
+// As StateAction objects are shared by all instances, they must keep per-instance data in the state machine
+// context passed to NewInstance, instead of their own fields.
+type TypedDefinition[StateIdentifier comparable, Event any, SmContext any] struct {
+	def *definition[StateIdentifier, Event, SmContext]
+}
+
+// Definition is the TypedDefinition for states accepting any EventContext and StateMachineContext.
+type Definition[StateIdentifier comparable] = TypedDefinition[StateIdentifier, EventContext, StateMachineContext]
+
+// NewInstance creates new state machine with smCtx context. The instance is not started, and StateMachineHandler.Start
+// must be called first, the same way as for state machines created with StateMachineBuilder.Build.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) NewInstance(smCtx SmContext) TypedStateMachineHandler[StateIdentifier, Event, SmContext] {
+	return d.def.newInstance(smCtx)
+}
+
+// Describe returns the state machine definition for analysis.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) Describe() Description[StateIdentifier] {
+	return d.def.Describe()
+}
+
+func (d *definition[StateIdentifier, Event, SmContext]) newInstance(smCtx SmContext) *stateMachine[StateIdentifier, Event, SmContext] {
+	s := &stateMachine[StateIdentifier, Event, SmContext]{
+		definition:     d,
+		currentStateID: d.defaultStateID,
+		smCtx:          smCtx,
+	}
+	// timeouts fire from the clock goroutines
+	if d.threadSafe || d.hasMailbox || d.hasTimeouts {
+		s.lock = &machineLock{}
+	}
+	if d.hasMailbox {
+		s.extra().mailbox = newMailbox[Event](d.mailboxCapacity, d.mailboxPolicy)
+	}
+	if len(d.middlewares) > 0 {
+		s.extra().handler = chainMiddlewares(s.processEventLocked, d.middlewares)
+	}
+	return s
+}
+
+// clone returns a copy of the definition, which is not affected by further changes of the builder.
+func (d *definition[StateIdentifier, Event, SmContext]) clone() *definition[StateIdentifier, Event, SmContext] {
+	c := *d
+	c.states = make(TypedStatesMap[StateIdentifier, Event, SmContext], len(d.states))
+	for stateID, st := range d.states {
+		transitions := make(Transitions[StateIdentifier], len(st.transitions))
+		for targetID := range st.transitions {
+			transitions[targetID] = struct{}{}
+		}
+		st.transitions = transitions
+		c.states[stateID] = st
+	}
+	c.order = append([]StateIdentifier(nil), d.order...)
+	c.listeners = append([]*TypedListener[StateIdentifier, Event](nil), d.listeners...)
+	c.middlewares = append([]TypedMiddleware[Event](nil), d.middlewares...)
+	return &c
+}
+
+func (d *definition[StateIdentifier, Event, SmContext]) parentOf(stateID StateIdentifier) (StateIdentifier, bool) {
+	st := d.states[stateID]
+	return st.parent, st.hasParent
+}
@@ -0,0 +1,113 @@
+package gfsm
+
+import (
+	"context"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefinitionInstances(t *testing.T) {
+	builder := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop)
+	def, err := builder.BuildDefinition()
+	assert.NoError(t, err)
+
+	// the definition is not affected by the builder anymore
+	builder.SetDefaultState(Stop)
+
+	first := def.NewInstance(&counterContext{limit: 2})
+	second := def.NewInstance(&counterContext{limit: 5})
+	first.Start()
+	second.Start()
+	assert.Equal(t, InProgress, first.State())
+	assert.Equal(t, InProgress, second.State())
+
+	assert.NoError(t, first.ProcessEvent(counterEvent{delta: 2}))
+	assert.NoError(t, second.ProcessEvent(counterEvent{delta: 2}))
+	assert.Equal(t, Stop, first.State())
+	assert.Equal(t, InProgress, second.State())
+
+	assert.Equal(t, def.Describe(), first.Describe())
+}
+
+func TestDefinitionInstanceListeners(t *testing.T) {
+	var transitions []string
+	record := func(tag string) TypedListener[StartStopSM, counterEvent] {
+		return TypedListener[StartStopSM, counterEvent]{
+			AfterTransition: func(_ context.Context, _ TypedTransitionInfo[StartStopSM, counterEvent]) {
+				transitions = append(transitions, tag)
+			},
+		}
+	}
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		AddListener(record("shared")).
+		BuildDefinition()
+	assert.NoError(t, err)
+
+	first := def.NewInstance(&counterContext{limit: 1})
+	second := def.NewInstance(&counterContext{limit: 1})
+	first.Subscribe(record("first"))
+	first.Start()
+	second.Start()
+
+	assert.NoError(t, first.ProcessEvent(counterEvent{delta: 1}))
+	assert.NoError(t, second.ProcessEvent(counterEvent{delta: 1}))
+	assert.Equal(t, []string{"shared", "first", "shared"}, transitions)
+}
+
+func TestDefinitionValidation(t *testing.T) {
+	def, err := NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &recordingState[ConnSM]{}, nil).
+		BuildDefinition()
+	assert.Nil(t, def)
+	assert.ErrorIs(t, err, ErrDeadEndState)
+}
+
+// instanceSizeLimit is the memory allowed for an instance: the definition, the current state, the context, the active
+// regions, the lock, the extras and the status, ten words on 64-bit platforms.
+const instanceSizeLimit = 10 * 8
+
+func TestDefinitionInstanceSize(t *testing.T) {
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		BuildDefinition()
+	assert.NoError(t, err)
+	smCtx := &counterContext{limit: 1}
+
+	// an instance is a single allocation, the rarely used fields are allocated on the first use
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = def.NewInstance(smCtx)
+	})
+	assert.Equal(t, 1.0, allocs)
+	assert.LessOrEqual(t, unsafe.Sizeof(stateMachine[StartStopSM, counterEvent, *counterContext]{}), uintptr(instanceSizeLimit))
+}
+
+func BenchmarkNewInstance(b *testing.B) {
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		BuildDefinition()
+	if err != nil {
+		b.Fatal(err)
+	}
+	smCtx := &counterContext{limit: 1}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = def.NewInstance(smCtx)
+	}
+}
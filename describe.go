@@ -0,0 +1,119 @@
+package gfsm
+
+// Description is a read-only view of a state machine definition, which allows to analyse the states graph, e.g. with
+// the analysis package.
+type Description[StateIdentifier comparable] struct {
+	// Name is the state machine name set by StateMachineBuilder.SetSMName.
+	Name string
+	// DefaultState is the state entered on Start.
+	DefaultState StateIdentifier
+	// States lists all registered states in registration order.
+	States []StateDescription[StateIdentifier]
+	// PseudoStates lists all registered pseudo-states in registration order.
+	PseudoStates []PseudoStateDescription[StateIdentifier]
+}
+
+// PseudoStateDescription describes a single pseudo-state of the state machine.
+type PseudoStateDescription[StateIdentifier comparable] struct {
+	ID   StateIdentifier
+	Kind PseudoStateKind
+	// Parent is the composite state a history pseudo-state belongs to.
+	Parent StateIdentifier
+	// Transitions lists branch targets of a choice or junction pseudo-state in declaration order.
+	Transitions []StateIdentifier
+}
+
+// StateDescription describes a single state of the state machine.
+type StateDescription[StateIdentifier comparable] struct {
+	ID StateIdentifier
+	// Parent is the enclosing composite state, valid only if HasParent is set.
+	Parent    StateIdentifier
+	HasParent bool
+	// SubStates lists sub-states of a composite state in registration order. For parallel states these are regions.
+	SubStates []StateIdentifier
+	// DefaultSubState is the sub-state entered together with a composite state, valid only if Composite is set and
+	// Parallel is not.
+	DefaultSubState StateIdentifier
+	Composite       bool
+	Parallel        bool
+	Final           bool
+	// SelfTransition tells whether Execute returning the state's own identifier re-enters the state.
+	SelfTransition SelfTransitionKind
+	// Transitions lists allowed targets in declaration order, including targets of declarative transitions.
+	Transitions []StateIdentifier
+}
+
+func (d *definition[StateIdentifier, Event, SmContext]) Describe() Description[StateIdentifier] {
+	order := d.order
+	if order == nil {
+		// state machines created without the builder have no registration order
+		for stateID := range d.states {
+			order = append(order, stateID)
+		}
+	}
+
+	desc := Description[StateIdentifier]{
+		Name:         d.name,
+		DefaultState: d.defaultStateID,
+		States:       make([]StateDescription[StateIdentifier], 0, len(order)),
+	}
+	children := map[StateIdentifier][]StateIdentifier{}
+	for _, stateID := range order {
+		if st := d.states[stateID]; st.hasParent {
+			children[st.parent] = append(children[st.parent], stateID)
+		}
+	}
+	for _, stateID := range order {
+		st := d.states[stateID]
+		desc.States = append(desc.States, StateDescription[StateIdentifier]{
+			ID:              stateID,
+			Parent:          st.parent,
+			HasParent:       st.hasParent,
+			SubStates:       children[stateID],
+			DefaultSubState: st.defaultSubState,
+			Composite:       st.composite,
+			Parallel:        st.parallel,
+			Final:           st.final,
+			SelfTransition:  selfTransitionKind(st.externalSelf),
+			Transitions:     st.targetsInOrder(),
+		})
+	}
+	for _, pseudoID := range d.pseudoOrder {
+		pseudo := d.pseudo[pseudoID]
+		var targets []StateIdentifier
+		for _, b := range pseudo.branches {
+			targets = append(targets, b.targetID)
+		}
+		desc.PseudoStates = append(desc.PseudoStates, PseudoStateDescription[StateIdentifier]{
+			ID:          pseudoID,
+			Kind:        pseudo.kind,
+			Parent:      pseudo.parent,
+			Transitions: targets,
+		})
+	}
+	return desc
+}
+
+// targetsInOrder returns the allowed transitions in declaration order if it is known.
+func (st *state[StateIdentifier, Event, SmContext]) targetsInOrder() []StateIdentifier {
+	if len(st.targets) == len(st.transitions) {
+		return append([]StateIdentifier(nil), st.targets...)
+	}
+	targets := make([]StateIdentifier, 0, len(st.transitions))
+	for targetID := range st.transitions {
+		targets = append(targets, targetID)
+	}
+	return targets
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Describe() Description[StateIdentifier] {
+	s.link()
+	return s.def.Describe()
+}
+
+func selfTransitionKind(external bool) SelfTransitionKind {
+	if external {
+		return ExternalTransition
+	}
+	return InternalTransition
+}
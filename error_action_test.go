@@ -0,0 +1,83 @@
+package gfsm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errLimitExceeded = errors.New("limit exceeded")
+
+// limitedState accepts only positive deltas and fails once the limit is exceeded.
+type limitedState struct {
+	countingState
+}
+
+func (s *limitedState) TryExecute(smCtx *counterContext, eventCtx counterEvent) (StartStopSM, error) {
+	if eventCtx.delta <= 0 {
+		return InProgress, ErrEventRejected
+	}
+	if smCtx.value+eventCtx.delta > smCtx.limit {
+		return InProgress, errLimitExceeded
+	}
+	return s.Execute(smCtx, eventCtx), nil
+}
+
+func TestTryExecuteErrors(t *testing.T) {
+	smCtx := &counterContext{limit: 3}
+	sm := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		SetSmContext(smCtx).
+		RegisterState(InProgress, &limitedState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		Build()
+	sm.Start()
+
+	err := sm.ProcessEvent(counterEvent{delta: -1})
+	assert.ErrorIs(t, err, ErrEventRejected)
+	var execErr *ExecuteError[StartStopSM, counterEvent]
+	assert.ErrorAs(t, err, &execErr)
+	assert.Equal(t, InProgress, execErr.State)
+	assert.Equal(t, counterEvent{delta: -1}, execErr.Event)
+
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 2}))
+	err = sm.ProcessEvent(counterEvent{delta: 2})
+	assert.ErrorIs(t, err, errLimitExceeded)
+	assert.Equal(t, InProgress, sm.State())
+	assert.Equal(t, 2, smCtx.value)
+
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 1}))
+	assert.Equal(t, Stop, sm.State())
+}
+
+func TestTryExecuteStopsBubbling(t *testing.T) {
+	var journal []string
+	sm := NewBuilder[ConnSM]().
+		SetDefaultState(Connected).
+		RegisterState(Connected, &recordingState[ConnSM]{id: Connected, journal: &journal}, nil).
+		RegisterSubState(Connected, Idle, &rejectingState{}, nil).
+		SetDefaultSubState(Connected, Idle).
+		Build()
+	sm.Start()
+
+	journal = nil
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("work")), ErrEventRejected)
+	assert.Empty(t, journal)
+}
+
+type rejectingState struct{}
+
+func (s *rejectingState) OnEnter(_ StateMachineContext) {
+}
+
+func (s *rejectingState) OnExit(_ StateMachineContext) {
+}
+
+func (s *rejectingState) Execute(_ StateMachineContext, _ EventContext) ConnSM {
+	return Idle
+}
+
+func (s *rejectingState) TryExecute(_ StateMachineContext, _ EventContext) (ConnSM, error) {
+	return Idle, ErrEventRejected
+}
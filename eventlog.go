@@ -0,0 +1,225 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+var (
+	// ErrNoEventLog is returned by Replay calls for state machines without an event log.
+	ErrNoEventLog = fmt.Errorf("state machine has no event log")
+	// ErrReplayDiverged is matched by DivergenceError with errors.Is.
+	ErrReplayDiverged = fmt.Errorf("replay diverged from the event log")
+)
+
+// RecordKind is the kind of a call recorded in the event log.
+type RecordKind int
+
+const (
+	// RecordEvent is an event processed with ProcessEvent, Post or Send, or the event of a state timeout set with
+	// StateMachineBuilder.SetStateTimeoutEvent.
+	RecordEvent RecordKind = iota
+	// RecordChangeState is a ChangeState call, the requested state is kept in TypedEventRecord.State.
+	RecordChangeState
+	// RecordReset is a Reset call.
+	RecordReset
+	// RecordTimeout is the transition of a state timeout set with StateMachineBuilder.SetStateTimeout, the timed
+	// out state is kept in TypedEventRecord.State.
+	RecordTimeout
+)
+
+func (k RecordKind) String() string {
+	switch k {
+	case RecordEvent:
+		return "event"
+	case RecordChangeState:
+		return "change state"
+	case RecordReset:
+		return "reset"
+	case RecordTimeout:
+		return "timeout"
+	default:
+		return fmt.Sprintf("RecordKind(%d)", int(k))
+	}
+}
+
+// TypedEventRecord is an event processed by a state machine instance, or another call changing its states, together
+// with its outcome.
+type TypedEventRecord[StateIdentifier comparable, Event any] struct {
+	// Index is the position of the record in the instance log, starting from zero.
+	Index int `json:"index"`
+	// Kind is the recorded call, records of events have zero kind.
+	Kind RecordKind `json:"kind,omitempty"`
+	// Event is the processed event, after all middlewares. It is the zero value for other kinds of records.
+	Event Event `json:"event"`
+	// State is the state requested by ChangeState, or the timed out state, it is valid for RecordChangeState and
+	// RecordTimeout records only.
+	State StateIdentifier `json:"state,omitzero"`
+	// Source is the value State() returned before the event processing.
+	Source StateIdentifier `json:"source"`
+	// Target is the value State() returned after the event processing, it is equal to Source if the state was not
+	// changed.
+	Target StateIdentifier `json:"target"`
+	// ActiveStates are the active leaf states of orthogonal regions after the event processing, it is empty without
+	// active regions.
+	ActiveStates []StateIdentifier `json:"active_states,omitempty"`
+	// Err is the text of the error returned from the event processing, it is empty on success.
+	Err string `json:"err,omitempty"`
+}
+
+// EventRecord is the TypedEventRecord for any EventContext.
+type EventRecord[StateIdentifier comparable] = TypedEventRecord[StateIdentifier, EventContext]
+
+// TypedEventLog keeps the events processed by state machine instances keyed by instance ID. The store package
+// provides in-memory and file implementations. Event logs must be safe for concurrent use, as many state machine
+// instances can share one log.
+type TypedEventLog[StateIdentifier comparable, Event any] interface {
+	// Append adds the record to the end of the instance id log.
+	Append(id string, record TypedEventRecord[StateIdentifier, Event]) error
+	// Records returns the instance id log in the order of Append calls, it is empty for unknown instances.
+	Records(id string) ([]TypedEventRecord[StateIdentifier, Event], error)
+}
+
+// EventLog is the TypedEventLog for any EventContext.
+type EventLog[StateIdentifier comparable] = TypedEventLog[StateIdentifier, EventContext]
+
+// DivergenceError is returned by Replay if a replayed record has a different outcome than the recorded one.
+type DivergenceError[StateIdentifier comparable, Event any] struct {
+	// Index is the index of the diverged record.
+	Index int
+	// Recorded is the diverged record.
+	Recorded TypedEventRecord[StateIdentifier, Event]
+	// Replayed is the outcome of the replayed record.
+	Replayed TypedEventRecord[StateIdentifier, Event]
+}
+
+func (e *DivergenceError[StateIdentifier, Event]) Error() string {
+	subject := fmt.Sprint(e.Recorded.Event)
+	switch e.Recorded.Kind {
+	case RecordChangeState, RecordTimeout:
+		subject = fmt.Sprintf("%v %v", e.Recorded.Kind, e.Recorded.State)
+	case RecordReset:
+		subject = e.Recorded.Kind.String()
+	}
+	return fmt.Sprintf("event %d (%s) diverged: recorded %v -> %v %q, replayed %v -> %v %q", e.Index, subject,
+		e.Recorded.Source, e.Recorded.Target, e.Recorded.Err, e.Replayed.Source, e.Replayed.Target, e.Replayed.Err)
+}
+
+func (e *DivergenceError[StateIdentifier, Event]) Unwrap() error {
+	return ErrReplayDiverged
+}
+
+// Replay rebuilds the state machine instance id from the definition event log. New instance is started with smCtx
+// context, and all the logged events are processed again by the same StateAction implementations, without
+// middlewares, as the logged events have already passed them. The logged ChangeState and Reset calls and timeout
+// transitions are made again in the same order. Listeners are notified as usual. If a record leads to a different
+// state, active states or error than recorded, Replay stops and returns *DivergenceError. On success, the instance
+// keeps appending to the same log.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) Replay(
+	id string,
+	smCtx SmContext) (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+
+	return d.def.replay(id, smCtx)
+}
+
+func (d *definition[StateIdentifier, Event, SmContext]) replay(
+	id string,
+	smCtx SmContext) (*stateMachine[StateIdentifier, Event, SmContext], error) {
+
+	if d.eventLog == nil {
+		return nil, ErrNoEventLog
+	}
+	records, err := d.eventLog.Records(id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read event log of %q: %w", id, err)
+	}
+
+	s := d.newInstance(smCtx)
+	s.setID(id)
+	s.lockEvents()
+	defer s.unlockEvents()
+	s.setStatus(StatusRunning)
+
+	ctx := context.Background()
+	entered := leafSet[StateIdentifier]{}
+	s.enter(ctx, s.currentStateID, false, s.currentStateID, &entered)
+	s.setConfiguration(entered)
+	var noEvent Event
+	var noState StateIdentifier
+	s.notify(ctx, hookStarted, noState, s.currentStateID, noEvent, nil)
+	_ = s.complete(ctx, noEvent)
+
+	for i, recorded := range records {
+		stateID := s.currentStateID
+		err := s.protect(ctx, func() error {
+			return s.apply(ctx, recorded.Kind, recorded.State, recorded.Event)
+		})
+		replayed := s.eventRecord(i, recorded.Kind, recorded.State, stateID, recorded.Event, err)
+		if !sameOutcome(recorded, replayed) {
+			return nil, &DivergenceError[StateIdentifier, Event]{Index: i, Recorded: recorded, Replayed: replayed}
+		}
+	}
+	s.extra().logged = len(records)
+	s.markChanged()
+	if err := s.writeThrough(nil); err != nil {
+		return nil, err
+	}
+	// the mailbox is started only for successfully replayed instances, others are just dropped
+	s.startMailbox()
+	return s, nil
+}
+
+// sameOutcome compares the outcomes of two event records.
+func sameOutcome[StateIdentifier comparable, Event any](a, b TypedEventRecord[StateIdentifier, Event]) bool {
+	return a.Source == b.Source && a.Target == b.Target && slices.Equal(a.ActiveStates, b.ActiveStates) &&
+		a.Err == b.Err
+}
+
+// eventRecord describes the call of kind with the state and eventCtx arguments made from the stateID state with err
+// result.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) eventRecord(
+	index int,
+	kind RecordKind,
+	state StateIdentifier,
+	stateID StateIdentifier,
+	eventCtx Event,
+	err error) TypedEventRecord[StateIdentifier, Event] {
+
+	record := TypedEventRecord[StateIdentifier, Event]{
+		Index:        index,
+		Kind:         kind,
+		Event:        eventCtx,
+		State:        state,
+		Source:       stateID,
+		Target:       s.currentStateID,
+		ActiveStates: append([]StateIdentifier(nil), s.activeLeaves...),
+	}
+	if err != nil {
+		record.Err = err.Error()
+	}
+	return record
+}
+
+// logEvent appends the call of kind with the state and eventCtx arguments made from the stateID state with err result
+// to the event log, if any. The result of the call err is returned if it is not nil.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) logEvent(
+	kind RecordKind,
+	state StateIdentifier,
+	stateID StateIdentifier,
+	eventCtx Event,
+	err error) error {
+
+	if s.eventLog == nil {
+		return err
+	}
+	x := s.extra()
+	if logErr := s.eventLog.Append(x.id, s.eventRecord(x.logged, kind, state, stateID, eventCtx, err)); logErr != nil {
+		if err == nil {
+			return fmt.Errorf("cannot log event %d of %q: %w", x.logged, x.id, logErr)
+		}
+		return err
+	}
+	x.logged++
+	return err
+}
@@ -0,0 +1,131 @@
+package gfsm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sliceLog is the TypedEventLog for tests, the store package cannot be used here because of the import cycle.
+type sliceLog[StateIdentifier comparable, Event any] struct {
+	records map[string][]TypedEventRecord[StateIdentifier, Event]
+}
+
+func newSliceLog[StateIdentifier comparable, Event any]() *sliceLog[StateIdentifier, Event] {
+	return &sliceLog[StateIdentifier, Event]{records: map[string][]TypedEventRecord[StateIdentifier, Event]{}}
+}
+
+func (l *sliceLog[StateIdentifier, Event]) Append(id string, record TypedEventRecord[StateIdentifier, Event]) error {
+	l.records[id] = append(l.records[id], record)
+	return nil
+}
+
+func (l *sliceLog[StateIdentifier, Event]) Records(id string) ([]TypedEventRecord[StateIdentifier, Event], error) {
+	return l.records[id], nil
+}
+
+func newCounterDefinition(t *testing.T, log TypedEventLog[StartStopSM, counterEvent]) *TypedDefinition[StartStopSM, counterEvent, *counterContext] {
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		SetEventLog(log).
+		BuildDefinition()
+	assert.NoError(t, err)
+	return def
+}
+
+func TestEventLog(t *testing.T) {
+	var journal []string
+	log := newSliceLog[ConnSM, EventContext]()
+	sm := newConnBuilder(&journal).
+		SetEventLog(log).
+		SetInstanceID("conn-1").
+		Build()
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("unknown")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	assert.Equal(t, []EventRecord[ConnSM]{
+		{Index: 0, Event: connEvent("connect"), Source: Disconnected, Target: Idle},
+		{Index: 1, Event: connEvent("unknown"), Source: Idle, Target: Idle},
+		{Index: 2, Event: connEvent("work"), Source: Idle, Target: Busy},
+	}, log.records["conn-1"])
+
+	journal = nil
+	replayed, err := newConnBuilder(&journal).
+		SetEventLog(log).
+		SetInstanceID("conn-1").
+		Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, Busy, replayed.State())
+	assert.Equal(t, []string{"enter 0", "execute 0", "exit 0", "enter 1", "enter 2", "execute 2", "execute 1",
+		"execute 2", "exit 2", "enter 3"}, journal)
+
+	// the replayed instance continues the log
+	assert.NoError(t, replayed.ProcessEvent(connEvent("done")))
+	assert.Len(t, log.records["conn-1"], 4)
+	assert.Equal(t, 3, log.records["conn-1"][3].Index)
+}
+
+func TestReplayDivergence(t *testing.T) {
+	log := newSliceLog[StartStopSM, counterEvent]()
+	def := newCounterDefinition(t, log)
+
+	sm := def.NewPersistentInstance("counter", &counterContext{limit: 3})
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 1}))
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 2}))
+	assert.Equal(t, Stop, sm.State())
+
+	replayed, err := def.Replay("counter", &counterContext{limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, Stop, replayed.State())
+
+	// the same events do not reach the limit any more
+	_, err = def.Replay("counter", &counterContext{limit: 5})
+	assert.ErrorIs(t, err, ErrReplayDiverged)
+	var divergence *DivergenceError[StartStopSM, counterEvent]
+	assert.ErrorAs(t, err, &divergence)
+	assert.Equal(t, 1, divergence.Index)
+	assert.Equal(t, Stop, divergence.Recorded.Target)
+	assert.Equal(t, InProgress, divergence.Replayed.Target)
+
+	_, err = newConnBuilder(nil).Replay()
+	assert.ErrorIs(t, err, ErrNoEventLog)
+}
+
+func TestReplayStateChanges(t *testing.T) {
+	var journal []string
+	log := newSliceLog[ConnSM, EventContext]()
+	clock := NewFakeClock(time.Unix(0, 0))
+	builder := newConnBuilder(&journal).
+		SetEventLog(log).
+		SetInstanceID("conn-1").
+		SetClock(clock).
+		SetStateTimeout(Busy, 5*time.Second, Idle)
+	sm := builder.Build()
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.Reset())
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).ChangeState(Busy))
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, Idle, sm.State())
+
+	assert.Equal(t, []EventRecord[ConnSM]{
+		{Index: 0, Event: connEvent("connect"), Source: Disconnected, Target: Idle},
+		{Index: 1, Kind: RecordReset, Source: Idle, Target: Disconnected},
+		{Index: 2, Event: connEvent("connect"), Source: Disconnected, Target: Idle},
+		{Index: 3, Kind: RecordChangeState, State: Busy, Source: Idle, Target: Busy},
+		{Index: 4, Kind: RecordTimeout, State: Busy, Source: Busy, Target: Idle},
+	}, log.records["conn-1"])
+
+	// the calls are made again instead of being reported as divergence
+	replayed, err := builder.Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, Idle, replayed.State())
+}
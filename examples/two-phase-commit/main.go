@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	gfsm2 "github.com/astavonin/gfsm"
 )
@@ -134,18 +135,25 @@ func (s *responseState) Execute(_ gfsm2.StateMachineContext, eventCtx gfsm2.Even
 
 //go:generate gfsm_uml -format=plantuml
 func main() {
+	cCtx := &coordinatorContext{partCnt: 3}
 	sm := gfsm2.NewBuilder[State]().
 		SetSMName("TwoPhaseCommit").
 		SetDefaultState(Init).
-		SetSmContext(&coordinatorContext{partCnt: 3}).
+		SetSmContext(cCtx).
 		RegisterState(Init, &initState{}, []State{Wait}).
 		RegisterState(Wait, &waitState{}, []State{Abort, Commit}).
+		// aborting the commit if not all votes arrived in time
+		SetStateTimeout(Wait, 10*time.Second, Abort).
 		RegisterState(Abort, &responseState{
 			keepResp: Abort,
 		}, []State{Init}).
 		RegisterState(Commit, &responseState{
 			keepResp: Commit,
 		}, []State{Init}).
+		// new commit requests are processed once the current one is done and the coordinator is back in Init
+		DeferEvent(Wait, commitRequest{}).
+		DeferEvent(Abort, commitRequest{}).
+		DeferEvent(Commit, commitRequest{}).
 		Build()
 
 	sm.Start()
@@ -158,6 +166,11 @@ func main() {
 		return
 	}
 	fmt.Printf("SM state (postcommit request): %s\n", sm.State())
+	err = sm.ProcessEvent(commitRequest{"commit_2"})
+	if err != nil {
+		fmt.Printf("unable to defer request: %v\n", err)
+		return
+	}
 
 	for i := 0; i < 3; i++ {
 		err := sm.ProcessEvent(commitVote{commit: true})
@@ -176,5 +189,6 @@ func main() {
 		}
 		fmt.Printf("SM state (confirming): %s\n", sm.State())
 	}
-	fmt.Printf("SM state (post confirm): %s\n", sm.State())
+	// the deferred commit_2 request was processed right after the transition to Init
+	fmt.Printf("SM state (post confirm): %s, commit %s\n", sm.State(), cCtx.commitID)
 }
@@ -0,0 +1,100 @@
+package gfsm
+
+import "context"
+
+// completion is the transition taken by a composite state when it is complete, see
+// StateMachineBuilder.SetCompletionTransition.
+type completion[StateIdentifier comparable] struct {
+	targetID StateIdentifier
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Done() <-chan struct{} {
+	s.lockState()
+	defer s.unlockState()
+	x := s.extra()
+	if x.done == nil {
+		x.done = make(chan struct{})
+		if s.finished {
+			close(x.done)
+		}
+	}
+	return x.done
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) IsFinished() bool {
+	s.rlockState()
+	defer s.runlockState()
+	return s.finished
+}
+
+// isFinal reports whether the active configuration is a final state on the top level of the states hierarchy.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) isFinal() bool {
+	st := s.states[s.currentStateID]
+	return st.final && !st.hasParent && s.activeLeaves == nil
+}
+
+// finish marks the state machine finished and closes the Done channel, if it is created. It does nothing if the state machine is
+// already finished.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) finish(ctx context.Context, eventCtx Event) {
+	if s.finished {
+		return
+	}
+	s.lockState()
+	s.finished = true
+	if x := s.extras.Load(); x != nil && x.done != nil {
+		close(x.done)
+	}
+	s.unlockState()
+	s.notify(ctx, hookFinished, s.currentStateID, s.currentStateID, eventCtx, nil)
+}
+
+// unfinish makes the state machine ready to run again after Reset.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) unfinish() {
+	s.lockState()
+	defer s.unlockState()
+	if s.finished {
+		// the next Done call creates a new channel
+		s.finished = false
+		if x := s.extras.Load(); x != nil {
+			x.done = nil
+		}
+	}
+}
+
+// complete finishes the state machine if it has reached a top level final state, or takes the completion transition
+// of the innermost complete composite state, if any.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) complete(ctx context.Context, eventCtx Event) error {
+	if s.isFinal() {
+		s.finish(ctx, eventCtx)
+		return nil
+	}
+	leaves := s.activeLeaves
+	if leaves == nil {
+		leaves = []StateIdentifier{s.currentStateID}
+	}
+	for _, leafID := range leaves {
+		for stateID, ok := s.parentOf(leafID); ok; stateID, ok = s.parentOf(stateID) {
+			st := s.states[stateID]
+			if st.hasCompletion && s.isComplete(stateID) {
+				return s.changeState(ctx, stateID, st.completion.targetID, nil, eventCtx)
+			}
+		}
+	}
+	return nil
+}
+
+// isComplete reports whether the active sub-state of the composite state stateID is final, or, for parallel states,
+// whether all the regions are complete.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) isComplete(stateID StateIdentifier) bool {
+	st := s.states[stateID]
+	if !st.parallel {
+		return s.states[s.activeChildOf(stateID)].final
+	}
+	for _, regionID := range st.regions {
+		region := s.states[regionID]
+		if !(region.final || (region.composite && s.isComplete(regionID))) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package gfsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type JobSM int
+
+const (
+	Queued JobSM = iota
+	Running
+	Fetching
+	Building
+	Built
+	Succeeded
+	Cancelled
+)
+
+func newJobBuilder(journal *[]string) StateMachineBuilder[JobSM] {
+	state := func(id JobSM, routes map[connEvent]JobSM) *recordingState[JobSM] {
+		return &recordingState[JobSM]{id: id, journal: journal, routes: routes}
+	}
+	return NewBuilder[JobSM]().
+		SetDefaultState(Queued).
+		RegisterState(Queued, state(Queued, map[connEvent]JobSM{"run": Running, "cancel": Cancelled}), []JobSM{Running, Cancelled}).
+		RegisterState(Running, state(Running, map[connEvent]JobSM{"cancel": Cancelled}), []JobSM{Cancelled}).
+		RegisterSubState(Running, Fetching, state(Fetching, map[connEvent]JobSM{"fetched": Building}), []JobSM{Building}).
+		RegisterSubState(Running, Building, state(Building, map[connEvent]JobSM{"built": Built}), []JobSM{Built}).
+		RegisterSubState(Running, Built, state(Built, nil), nil).
+		SetDefaultSubState(Running, Fetching).
+		SetFinalState(Built).
+		SetCompletionTransition(Running, Succeeded).
+		RegisterState(Succeeded, state(Succeeded, nil), nil).
+		RegisterState(Cancelled, state(Cancelled, nil), nil).
+		SetFinalState(Succeeded).
+		SetFinalState(Cancelled)
+}
+
+func TestFinalState(t *testing.T) {
+	var journal []string
+	sm, err := newJobBuilder(&journal).BuildE()
+	assert.NoError(t, err)
+	sm.Start()
+	defer sm.Stop()
+	done := sm.Done()
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("cancel")))
+	assert.Equal(t, Cancelled, sm.State())
+	assert.True(t, sm.IsFinished())
+	select {
+	case <-done:
+	default:
+		assert.Fail(t, "Done channel is not closed")
+	}
+
+	journal = nil
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("run")), ErrFinished)
+	assert.Empty(t, journal)
+
+	sm.Reset()
+	assert.False(t, sm.IsFinished())
+	select {
+	case <-sm.Done():
+		assert.Fail(t, "Done channel is closed after Reset")
+	default:
+	}
+	assert.NoError(t, sm.ProcessEvent(connEvent("run")))
+	assert.Equal(t, Fetching, sm.State())
+}
+
+func TestCompletionTransition(t *testing.T) {
+	var journal []string
+	var finished []TransitionInfo[JobSM]
+	sm := newJobBuilder(&journal).
+		AddListener(Listener[JobSM]{
+			Finished: func(_ context.Context, info TransitionInfo[JobSM]) {
+				finished = append(finished, info)
+			},
+		}).
+		Build()
+	sm.Start()
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("run")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("fetched")))
+
+	// reaching the final sub-state completes the parent, which switches to Succeeded within the same event
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("built")))
+	assert.Equal(t, Succeeded, sm.State())
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 4", "exit 4", "exit 1", "enter 5"}, journal)
+	assert.True(t, sm.IsFinished())
+	assert.Equal(t, []TransitionInfo[JobSM]{{Source: Succeeded, Target: Succeeded, Event: connEvent("built")}}, finished)
+}
+
+func TestFinishedStateMachine(t *testing.T) {
+	var journal []string
+	state := func(id JobSM, routes map[connEvent]JobSM) *recordingState[JobSM] {
+		return &recordingState[JobSM]{id: id, journal: &journal, routes: routes}
+	}
+	sm := NewBuilder[JobSM]().
+		SetDefaultState(Queued).
+		RegisterState(Queued, state(Queued, map[connEvent]JobSM{"cancel": Cancelled}), []JobSM{Cancelled}).
+		RegisterState(Cancelled, state(Cancelled, nil), []JobSM{Succeeded}).
+		RegisterState(Succeeded, state(Succeeded, nil), nil).
+		SetFinalState(Cancelled).
+		SetFinalState(Succeeded).
+		Build()
+	assert.NoError(t, sm.Start())
+	assert.NoError(t, sm.ProcessEvent(connEvent("cancel")))
+	assert.True(t, sm.IsFinished())
+
+	// a transition between final states does not finish the state machine twice
+	assert.ErrorIs(t, sm.(*stateMachine[JobSM, EventContext, StateMachineContext]).ChangeState(Succeeded), ErrFinished)
+	assert.Equal(t, Cancelled, sm.State())
+
+	assert.NoError(t, sm.Stop())
+	assert.NotPanics(t, func() {
+		assert.ErrorIs(t, sm.Start(), ErrStopped)
+	})
+	assert.True(t, sm.IsFinished())
+}
+
+func TestCompletionTransitionValidation(t *testing.T) {
+	var journal []string
+	_, err := newJobBuilder(&journal).
+		SetCompletionTransition(Queued, Running).
+		BuildE()
+	assert.ErrorIs(t, err, ErrInvalidHierarchy)
+}
+
+func TestNestedStateMachineDone(t *testing.T) {
+	var journal []string
+	parent := newConnBuilder(&journal).
+		SetMailbox(4, OverflowBlock).
+		Build()
+	parent.Start()
+	defer parent.Stop()
+	assert.NoError(t, parent.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, parent.ProcessEvent(connEvent("work")))
+
+	// the job runs while the parent is busy, and its completion finishes the parent's work
+	var jobJournal []string
+	job := newJobBuilder(&jobJournal).
+		AddListener(Listener[JobSM]{
+			Finished: func(_ context.Context, _ TransitionInfo[JobSM]) {
+				assert.NoError(t, parent.Post(connEvent("done")))
+			},
+		}).
+		Build()
+	job.Start()
+	for _, ev := range []connEvent{"run", "fetched", "built"} {
+		assert.NoError(t, job.ProcessEvent(ev))
+	}
+
+	<-job.Done()
+	assert.Eventually(t, func() bool {
+		return parent.State() == Idle
+	}, time.Second, time.Millisecond)
+}
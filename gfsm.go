@@ -1,102 +1,693 @@
 // Package gfsm implements basic state machine functionality.
 package gfsm
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
 
 var (
 	ErrNoValidTransition = fmt.Errorf("no valid transition")
+	// ErrStopped is returned by Start, ProcessEvent, Post, Send, Stop, Reset and ChangeState calls made after Stop.
+	ErrStopped = fmt.Errorf("state machine is stopped")
+	// ErrFinished is returned by ProcessEvent, Post, Send and ChangeState after the state machine has reached a final
+	// state.
+	ErrFinished = fmt.Errorf("state machine is finished")
+	// ErrEventRejected is the error a state can return from TypedErrorAction.TryExecute for events it cannot accept.
+	ErrEventRejected = fmt.Errorf("event rejected")
 )
 
+// ExecuteError is returned by StateMachineHandler.ProcessEvent if TypedErrorAction.TryExecute or
+// TypedContextAction.ExecuteCtx of a state failed.
+type ExecuteError[StateIdentifier comparable, Event any] struct {
+	// State is the state which failed to process the event.
+	State StateIdentifier
+	// Event is the processed event.
+	Event Event
+	// Err is the error returned by TryExecute or ExecuteCtx.
+	Err error
+}
+
+func (e *ExecuteError[StateIdentifier, Event]) Error() string {
+	return fmt.Sprintf("state %v failed to process event %v: %v", e.State, e.Event, e.Err)
+}
+
+func (e *ExecuteError[StateIdentifier, Event]) Unwrap() error {
+	return e.Err
+}
+
 // Transitions represents all available transitions from the state.
 type Transitions[StateIdentifier comparable] map[StateIdentifier]struct{}
 
 // The state is a struct that is defined by the StateActions it can take, the Transitions it can make
-type state[StateIdentifier comparable] struct {
-	action      StateAction[StateIdentifier]
+// and, for hierarchical state machines, its position in the states tree.
+type state[StateIdentifier comparable, Event any, SmContext any] struct {
+	action      TypedStateAction[StateIdentifier, Event, SmContext]
 	transitions Transitions[StateIdentifier]
+
+	// parent is the enclosing composite state, valid only if hasParent is set.
+	parent    StateIdentifier
+	hasParent bool
+	// defaultSubState is the child entered together with a composite state, valid only if composite is set.
+	defaultSubState StateIdentifier
+	composite       bool
+	// regions are all sub-states of a parallel state in registration order. All of them are active together with
+	// the parallel state.
+	regions  []StateIdentifier
+	parallel bool
+
+	// guarded are declarative transitions evaluated before action.Execute.
+	guarded []guardedTransition[StateIdentifier, Event, SmContext]
+
+	// final is set for states marked with StateMachineBuilder.SetFinalState.
+	final bool
+	// externalSelf is set if Execute returning the state's own identifier exits and re-enters the state, see
+	// StateMachineBuilder.SetSelfTransition.
+	externalSelf bool
+	// targets keeps transitions in declaration order for Describe.
+	targets []StateIdentifier
+
+	// completion is taken when the composite state is complete, valid only if hasCompletion is set.
+	completion    completion[StateIdentifier]
+	hasCompletion bool
+
+	// actions are transition actions per target state set with StateMachineBuilder.SetTransitionAction.
+	actions map[StateIdentifier]TypedTransitionAction[Event, SmContext]
+
+	// deferred are the types of events deferred while the state is active, see StateMachineBuilder.DeferEvent.
+	deferred map[reflect.Type]struct{}
+
+	// timeout is armed on each entering of the state, valid only if hasTimeout is set.
+	timeout    stateTimeout[StateIdentifier, Event]
+	hasTimeout bool
 }
 
-// StatesMap represent full state machine transactions and allows to verify path from any state to another.
-// It is a map of StateIdentifiers to state
-type StatesMap[StateIdentifier comparable] map[StateIdentifier]state[StateIdentifier]
+// TypedStatesMap represent full state machine transactions. It is a map of StateIdentifiers to state, paths between
+// states can be verified with the analysis package using StateMachineHandler.Describe.
+type TypedStatesMap[StateIdentifier comparable, Event any, SmContext any] map[StateIdentifier]state[StateIdentifier, Event, SmContext]
 
-// StateMachineHandler is the main state machine interface. All manipulation with the state machine object shall
-// be performed using this interface.
-type StateMachineHandler[StateIdentifier comparable] interface {
+// StatesMap is the TypedStatesMap for states accepting any EventContext and StateMachineContext.
+type StatesMap[StateIdentifier comparable] = TypedStatesMap[StateIdentifier, EventContext, StateMachineContext]
+
+// TypedStateMachineHandler is the main state machine interface. All manipulation with the state machine object shall
+// be performed using this interface. Event and SmContext are the types of events and the state machine context
+// respectively.
+type TypedStateMachineHandler[StateIdentifier comparable, Event any, SmContext any] interface {
 	// Start is the first function that user MUST call before any further interactions with the state machine.
 	// On Start call, state machine will switch to the defined default state, which must be specified during state
-	// machine creation using StateMachineBuilder.SetDefaultState(...) call
-	Start()
+	// machine creation using StateMachineBuilder.SetDefaultState(...) call. Start of a running state machine returns
+	// ErrAlreadyStarted, and Start of a stopped one returns ErrStopped.
+	Start() error
+
+	// StartCtx is the same as Start, but passes ctx to TypedContextAction.OnEnterCtx of the entered states.
+	StartCtx(ctx context.Context) error
 
-	// Stop call shutdowns the state machine. Any further State or ProcessEvent are not permitted on stopped
-	// state machine.
-	Stop()
+	// Stop call shutdowns the state machine. Any further ProcessEvent calls are rejected with ErrStopped.
+	// Thread-safe state machines finish processing of the current event first. Stop returns ErrNotStarted if the
+	// state machine was not started, and ErrStopped if it is already stopped, OnExit callbacks are not called then.
+	Stop() error
 
-	// State returns current state machine state
+	// Status returns the lifecycle phase of the state machine.
+	Status() Status
+
+	// State returns current state machine state. For hierarchical state machines it is always the innermost
+	// (leaf) active state. If orthogonal regions are active, the innermost state containing all of them is returned.
+	// Transitions follow UML ordering: OnExit of the source states, then the transition action, then OnEnter of the
+	// target states. State returns the source state until the transition action completes, and the target state
+	// (which can be a composite one) while the target states are entered.
 	State() StateIdentifier
 
-	// ProcessEvent pass data to the sate machine for processing. The data will be forwarded to StateAction.Execute
-	// method of the current state. If the event processing will lead to unexpected transaction, ProcessEvent call
-	// will return ErrNoValidTransition error
-	ProcessEvent(eventCtx EventContext) error
+	// ActiveStates returns the full active configuration: the innermost active state of each active region in the
+	// regions registration order. Without orthogonal regions it contains State() only.
+	ActiveStates() []StateIdentifier
+
+	// ProcessEvent pass data to the sate machine for processing. The data passes through middlewares registered with
+	// StateMachineBuilder.Use, if any. Declarative transitions of the current state, registered with
+	// StateMachineBuilder.AddTransition, are evaluated first, and if none of them matches, the data will be forwarded
+	// to StateAction.Execute method of the current state. If the current state is a sub-state and
+	// does not handle the event (returns its own identifier), the event bubbles to the parent state's Execute and so
+	// on up to the root. With orthogonal regions, the event is dispatched to every active region, and bubbles to the
+	// parallel state only if none of the regions handled it.
+	// If the event processing will lead to unexpected transaction, ProcessEvent call will return
+	// ErrNoValidTransition error. If the state implements TypedErrorAction and fails to process the event,
+	// the returned error is *ExecuteError wrapping the state's error. Events are rejected with ErrNotStarted before
+	// Start and with ErrStopped after Stop.
+	ProcessEvent(eventCtx Event) error
+
+	// ProcessEventCtx is the same as ProcessEvent, but passes ctx to TypedContextAction callbacks of the states
+	// involved into the event processing. If ctx is done before the processing starts, the event is discarded and
+	// ctx.Err() is returned. Long-running TypedContextAction.ExecuteCtx implementations are expected to stop on
+	// ctx cancellation and return ctx.Err(), which is returned wrapped into ExecuteError.
+	ProcessEventCtx(ctx context.Context, eventCtx Event) error
+
+	// Post puts the event into the mailbox of a state machine built with StateMachineBuilder.SetMailbox and returns
+	// without waiting for the event processing. Events are processed one by one in the posting order by a dedicated
+	// goroutine, so state callbacks can post follow-up events to their own state machine, and these events will be
+	// processed after the current one. If the mailbox is full, OverflowPolicy defines the behaviour.
+	// State machines without mailbox return ErrNoMailbox.
+	Post(eventCtx Event) error
+
+	// Send puts the event into the mailbox the same way as Post does, but waits for the event processing and
+	// returns its result. ctx is passed to TypedContextAction callbacks the same way as ProcessEventCtx does.
+	// If ctx is done while the event is in the mailbox, it is discarded.
+	Send(ctx context.Context, eventCtx Event) error
+
+	// Reset will return the statemachine to its default state. It returns ErrNotStarted or ErrStopped if the state
+	// machine is not running.
+	Reset() error
+
+	// Done returns the channel closed when the state machine reaches a final state, which is marked with
+	// StateMachineBuilder.SetFinalState and has no parent state. Final sub-states complete their parent composite
+	// state instead, see StateMachineBuilder.SetCompletionTransition. Reset makes the state machine unfinished again,
+	// and a new channel is returned by further Done calls. To let a nested state machine driven by a state of another
+	// one signal completion to its parent, post an event to the parent from TypedListener.Finished of the nested one.
+	Done() <-chan struct{}
+
+	// IsFinished reports whether the state machine has reached a final state. Events processed by a finished state
+	// machine are rejected with ErrFinished.
+	IsFinished() bool
 
-	// Reset will return the statemachine to its default state
-	Reset()
+	// Subscribe adds the listener to the state machine and returns the function removing it. Listeners registered
+	// with StateMachineBuilder.AddListener are notified first. Subscribe and the returned function must not be called
+	// from state callbacks or listeners of a thread-safe state machine.
+	Subscribe(listener TypedListener[StateIdentifier, Event]) func()
+
+	// Describe returns the state machine definition for analysis.
+	Describe() Description[StateIdentifier]
+
+	// Snapshot returns the current state of the state machine, which can be serialized and restored later with
+	// StateMachineBuilder.Restore or TypedDefinition.Restore. The state machine context is included if
+	// StateMachineBuilder.SetContextCodec was called. Snapshot must not be called from state callbacks or listeners
+	// of a thread-safe state machine.
+	Snapshot() (Snapshot[StateIdentifier], error)
+
+	// Persist saves the snapshot into the store set with StateMachineBuilder.SetStore, using the instance ID. It
+	// returns ErrNoStore if the state machine has no store or no instance ID. Persist must not be called from state
+	// callbacks or listeners of a thread-safe state machine.
+	Persist() error
 }
 
-type stateMachine[StateIdentifier comparable] struct {
+// StateMachineHandler is the TypedStateMachineHandler accepting any EventContext and StateMachineContext.
+type StateMachineHandler[StateIdentifier comparable] = TypedStateMachineHandler[StateIdentifier, EventContext, StateMachineContext]
+
+// stateMachine is an instance of the state machine definition, it keeps the per-instance data only.
+type stateMachine[StateIdentifier comparable, Event any, SmContext any] struct {
+	*definition[StateIdentifier, Event, SmContext]
+
 	currentStateID StateIdentifier
-	defaultStateID StateIdentifier
-	states         StatesMap[StateIdentifier]
-	smCtx          StateMachineContext
-	name           string
+	smCtx          SmContext
+
+	// activeLeaves holds innermost active states of all regions while orthogonal regions are active, it is nil
+	// otherwise and currentStateID is the only active leaf.
+	activeLeaves []StateIdentifier
+
+	// lock is set for thread-safe state machines only.
+	lock *machineLock
+	// extras are allocated on the first use, see instanceExtras.
+	extras atomic.Pointer[instanceExtras[StateIdentifier, Event]]
+
+	// status is the lifecycle phase, it is changed under both the events and the state locks.
+	status   Status
+	finished bool
+}
+
+// instanceExtras holds the instance fields most state machines never use, they are kept out of stateMachine to make
+// instances small. The fields are guarded by the events lock, unless stated otherwise.
+type instanceExtras[StateIdentifier comparable, Event any] struct {
+	// mailbox is set for state machines processing events asynchronously only.
+	mailbox *mailbox[Event]
+	// handler is the middlewares chain ending with processEventLocked, it is set if there are middlewares only.
+	handler TypedEventHandler[Event]
+	// listeners replace the definition listeners once subscribed is set by Subscribe call.
+	listeners  []*TypedListener[StateIdentifier, Event]
+	subscribed bool
+	// id is the key of the instance snapshots in the store, the instance is not persisted if it is empty.
+	id string
+	// logged is the index of the next event record in the event log.
+	logged int
+	// changed is set on each change of the active states of a state machine with PersistWriteThrough policy until
+	// the snapshot is written through to the store.
+	changed bool
+	// timers are the armed timeouts of the active states.
+	timers map[StateIdentifier]*stateTimer
+	// done is closed when finished is set, it is created by the first Done call and guarded by the state lock.
+	done chan struct{}
+	// history keeps the last active sub-state of each exited composite state, it is used by history pseudo-states.
+	history map[StateIdentifier]StateIdentifier
+	// entering is the history pseudo-state entered by the transition in progress.
+	entering historyEntry[StateIdentifier]
+	// site is the callback being executed, it is reported if the callback panics. It is kept with panic recovery
+	// enabled only.
+	site callbackSite[StateIdentifier]
+	// exited are the states exited by the protected call in progress, in exit order. They are entered again if
+	// the call panics and the configuration is rolled back.
+	exited []StateIdentifier
+	// deferred are the events deferred by the active states in arrival order, redispatching is set while they are
+	// processed after a transition.
+	deferred      []Event
+	redispatching bool
+	// transitions counts the transitions made by a state machine deferring events, it tells whether processing of
+	// an event changed the active states.
+	transitions int
+}
+
+// extra returns the instance extras allocating them if needed. The extras can be allocated by both the events and
+// the state lock holders, so the pointer is atomic.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) extra() *instanceExtras[StateIdentifier, Event] {
+	if x := s.extras.Load(); x != nil {
+		return x
+	}
+	s.extras.CompareAndSwap(nil, &instanceExtras[StateIdentifier, Event]{})
+	return s.extras.Load()
+}
+
+// instanceID returns the key of the instance snapshots and event records.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) instanceID() string {
+	if x := s.extras.Load(); x != nil {
+		return x.id
+	}
+	return ""
+}
+
+// setID sets the key of the instance snapshots and event records.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) setID(id string) {
+	if id != "" {
+		s.extra().id = id
+	}
+}
+
+// markChanged records a change of the active states to be written through to the store.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) markChanged() {
+	if s.store != nil && s.persistPolicy == PersistWriteThrough && s.instanceID() != "" {
+		s.extra().changed = true
+	}
 }
 
-func (s *stateMachine[StateIdentifier]) Start() {
-	state := s.states[s.currentStateID]
-	state.action.OnEnter(s.smCtx)
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Start() error {
+	return s.StartCtx(context.Background())
 }
 
-func (s *stateMachine[StateIdentifier]) Stop() {
-	state := s.states[s.currentStateID]
-	state.action.OnExit(s.smCtx)
+func (s *stateMachine[StateIdentifier, Event, SmContext]) StartCtx(ctx context.Context) error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	switch s.status {
+	case StatusRunning:
+		return ErrAlreadyStarted
+	case StatusStopped:
+		return ErrStopped
+	}
+	s.setStatus(StatusRunning)
+
+	err := s.protect(ctx, func() error {
+		entered := leafSet[StateIdentifier]{}
+		s.enter(ctx, s.currentStateID, false, s.currentStateID, &entered)
+		s.setConfiguration(entered)
+
+		var noEvent Event
+		var noState StateIdentifier
+		s.notify(ctx, hookStarted, noState, s.currentStateID, noEvent, nil)
+		return s.complete(ctx, noEvent)
+	})
+	s.markChanged()
+	err = s.writeThrough(err)
+	s.startMailbox()
+	return err
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Stop() error {
+	var m *mailbox[Event]
+	defer func() {
+		// outside the events lock, so the mailbox goroutine can finish the current event
+		if m != nil {
+			m.stop()
+		}
+	}()
+	return s.stopLocked(&m)
 }
 
-func (s *stateMachine[StateIdentifier]) State() StateIdentifier {
+// stopLocked exits the active states under the events lock, and stores the mailbox to be stopped to m. Timers and
+// the mailbox are released even if a callback panics.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) stopLocked(m **mailbox[Event]) error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+	s.setStatus(StatusStopped)
+	defer func() {
+		// the states left active by a panic keep no timeouts
+		s.stopTimers()
+		*m = s.releaseMailbox()
+	}()
+
+	ctx := context.Background()
+	return s.protect(ctx, func() error {
+		s.exit(ctx, s.currentStateID, s.activeLeaves, s.currentStateID, false)
+		var noEvent Event
+		var noState StateIdentifier
+		s.notify(ctx, hookStopped, s.currentStateID, noState, noEvent, nil)
+		return nil
+	})
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) State() StateIdentifier {
+	s.rlockState()
+	defer s.runlockState()
 	return s.currentStateID
 }
 
-func (s *stateMachine[StateIdentifier]) ProcessEvent(eventCtx EventContext) error {
-	currentState := s.states[s.currentStateID]
-	nextStateID := currentState.action.Execute(s.smCtx, eventCtx)
+func (s *stateMachine[StateIdentifier, Event, SmContext]) ActiveStates() []StateIdentifier {
+	s.rlockState()
+	defer s.runlockState()
+	if s.activeLeaves == nil {
+		return []StateIdentifier{s.currentStateID}
+	}
+	return append([]StateIdentifier(nil), s.activeLeaves...)
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) ProcessEvent(eventCtx Event) error {
+	return s.ProcessEventCtx(context.Background(), eventCtx)
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) ProcessEventCtx(ctx context.Context, eventCtx Event) error {
+	if s.Status() == StatusCreated {
+		// unlike Post, do not keep the event until Start
+		return ErrNotStarted
+	}
+	if s.eventsMailbox() != nil {
+		// keep the order with the events posted to the mailbox
+		return s.Send(ctx, eventCtx)
+	}
+	return s.handleEvent(ctx, eventCtx)
+}
+
+// processEventLocked processes the event under the events lock.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) processEventLocked(ctx context.Context, eventCtx Event) error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+	if s.finished {
+		return ErrFinished
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.processLogged(ctx, eventCtx)
+}
+
+// processLogged processes the event, logs and persists the result.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) processLogged(ctx context.Context, eventCtx Event) error {
+	var noState StateIdentifier
+	return s.applyLogged(ctx, RecordEvent, noState, eventCtx)
+}
+
+// applyLogged makes the call of kind, logs and persists the result.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) applyLogged(
+	ctx context.Context,
+	kind RecordKind,
+	state StateIdentifier,
+	eventCtx Event) error {
+
+	stateID := s.currentStateID
+	err := s.protect(ctx, func() error {
+		return s.apply(ctx, kind, state, eventCtx)
+	})
+	if kind == RecordReset {
+		// the default state is entered again even if it was active
+		s.markChanged()
+	}
+	return s.writeThrough(s.logEvent(kind, state, stateID, eventCtx, err))
+}
+
+// apply makes the call of kind, which is replayed from the event log the same way: processes eventCtx, changes the
+// state to state, resets the state machine, or takes the timeout transition of the state.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) apply(
+	ctx context.Context,
+	kind RecordKind,
+	state StateIdentifier,
+	eventCtx Event) error {
+
+	var noEvent Event
+	switch kind {
+	case RecordChangeState:
+		if err := s.changeState(ctx, s.currentStateID, state, nil, noEvent); err != nil {
+			return err
+		}
+		return s.redispatch(ctx)
+	case RecordReset:
+		return s.reset(ctx)
+	case RecordTimeout:
+		// the timer is stopped already unless the timeout is replayed
+		s.stopTimer(state)
+		if err := s.changeState(ctx, state, s.states[state].timeout.targetID, nil, noEvent); err != nil {
+			return err
+		}
+		return s.redispatch(ctx)
+	default:
+		return s.processEvent(ctx, eventCtx)
+	}
+}
+
+// processEvent dispatches the event to the active states, or defers it. The deferred events are processed again if
+// the event made a transition.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) processEvent(ctx context.Context, eventCtx Event) error {
+	if s.defers(eventCtx) {
+		return s.deferEvent(eventCtx)
+	}
+	if !s.hasDeferred {
+		return s.dispatchEvent(ctx, eventCtx)
+	}
+	x := s.extra()
+	transitions := x.transitions
+	if err := s.dispatchEvent(ctx, eventCtx); err != nil || x.transitions == transitions {
+		return err
+	}
+	return s.redispatch(ctx)
+}
+
+// dispatchEvent dispatches the event to the active states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) dispatchEvent(ctx context.Context, eventCtx Event) error {
+	stateID := s.currentStateID
+	if s.activeLeaves != nil {
+		handled, err := s.dispatchRegions(ctx, stateID, eventCtx)
+		if handled || err != nil {
+			return err
+		}
+		// none of the regions handled the event, it continues to bubble from the parallel state
+	}
+	handled, err := s.dispatch(ctx, stateID, false, stateID, eventCtx)
+	if !handled {
+		s.notify(ctx, hookEventIgnored, stateID, stateID, eventCtx, nil)
+	}
+	return err
+}
+
+// dispatch passes the event to stateID and bubbles it up to, but not including, untilID. It reports whether any of
+// the states handled the event.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) dispatch(
+	ctx context.Context,
+	stateID StateIdentifier,
+	hasUntil bool,
+	untilID StateIdentifier,
+	eventCtx Event) (bool, error) {
+
+	for ok := true; ok && !(hasUntil && stateID == untilID); stateID, ok = s.parentOf(stateID) {
+		currentState := s.states[stateID]
+		s.enterSite(stateID, PhaseGuard)
+		transition := currentState.findTransition(s.smCtx, eventCtx)
+		s.leaveSite()
+		if transition != nil && transition.internal {
+			if transition.action != nil {
+				s.enterSite(stateID, PhaseTransitionAction)
+				transition.action(s.smCtx, eventCtx)
+				s.leaveSite()
+			}
+			return true, nil
+		}
+		if transition != nil {
+			return true, s.changeState(ctx, stateID, transition.targetID, transition.action, eventCtx)
+		}
+		s.enterSite(stateID, PhaseExecute)
+		nextStateID, err := currentState.execute(ctx, s.smCtx, eventCtx)
+		s.leaveSite()
+		if err != nil {
+			return true, &ExecuteError[StateIdentifier, Event]{State: stateID, Event: eventCtx, Err: err}
+		}
+		// the state did not handle the event, let the parent state try
+		if nextStateID == stateID && !currentState.externalSelf {
+			continue
+		}
+
+		return true, s.changeState(ctx, stateID, nextStateID, nil, eventCtx)
+	}
+
 	// do not need to change state
-	if nextStateID == s.currentStateID {
-		return nil
+	return false, nil
+}
+
+// dispatchRegions passes the event to every active region of the parallel state parallelID. Regions are visited in
+// registration order, a region exited by a transition taken in one of the previous regions is skipped.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) dispatchRegions(
+	ctx context.Context,
+	parallelID StateIdentifier,
+	eventCtx Event) (bool, error) {
+
+	handled := false
+	for _, regionID := range s.states[parallelID].regions {
+		if !s.isActive(regionID) {
+			continue
+		}
+		// walk down the region to its active leaf or to a nested parallel state
+		stateID := regionID
+		for st := s.states[stateID]; st.composite && !st.parallel; st = s.states[stateID] {
+			stateID = s.activeChildOf(stateID)
+		}
+		if s.states[stateID].parallel {
+			innerHandled, err := s.dispatchRegions(ctx, stateID, eventCtx)
+			if err != nil {
+				return true, err
+			}
+			if innerHandled {
+				handled = true
+				continue
+			}
+		}
+		regionHandled, err := s.dispatch(ctx, stateID, true, parallelID, eventCtx)
+		if err != nil {
+			return true, err
+		}
+		handled = handled || regionHandled
+	}
+	return handled, nil
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) ChangeState(nextStateID StateIdentifier) error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	if err := s.checkRunning(); err != nil {
+		return err
+	}
+	if s.finished {
+		return ErrFinished
+	}
+
+	var noEvent Event
+	return s.applyLogged(context.Background(), RecordChangeState, nextStateID, noEvent)
+}
+
+// changeState performs transition declared by sourceID, which is either an active state or one of its ancestors.
+// All active states below the least common ancestor of sourceID and nextStateID are exited, then the transition
+// action is executed, if any (the one set with StateMachineBuilder.SetTransitionAction is used if action is nil),
+// and all states from the least common ancestor down to nextStateID (and its default
+// sub-states) are entered. A history pseudo-state nextStateID enters its parent state and the saved sub-states.
+// Junction pseudo-states select the target before any state is exited, and choice pseudo-states select it after the
+// transition action, exiting all active states below the least common ancestor of sourceID and all the choice
+// targets.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) changeState(
+	ctx context.Context,
+	sourceID, nextStateID StateIdentifier,
+	action TypedTransitionAction[Event, SmContext],
+	eventCtx Event) error {
+
+	prevStateID := s.currentStateID
+	if action == nil {
+		action = s.states[sourceID].actions[nextStateID]
 	}
+	if !s.canSwitch(sourceID, nextStateID) {
+		err := fmt.Errorf("cannot switch from %v to %v: %w", sourceID, nextStateID, ErrNoValidTransition)
+		s.notify(ctx, hookTransitionRejected, prevStateID, nextStateID, eventCtx, err)
+		return err
+	}
+	targetID, ok := s.selectJunctions(nextStateID, eventCtx)
+	if !ok {
+		err := fmt.Errorf("no branch of junction %v can be taken: %w", targetID, ErrNoValidTransition)
+		s.notify(ctx, hookTransitionRejected, prevStateID, nextStateID, eventCtx, err)
+		return err
+	}
+	if err := s.checkBranches(sourceID, nextStateID, targetID); err != nil {
+		s.notify(ctx, hookTransitionRejected, prevStateID, nextStateID, eventCtx, err)
+		return err
+	}
+	nextStateID, history := s.resolveHistory(targetID)
+	s.notify(ctx, hookBeforeTransition, prevStateID, nextStateID, eventCtx, nil)
+	lca, hasLCA := s.transitionDomain(sourceID, nextStateID)
+
+	leafID, leaves := s.currentStateID, s.activeLeaves
+	s.exit(ctx, leafID, leaves, lca, hasLCA)
+	if action != nil {
+		s.enterSite(sourceID, PhaseTransitionAction)
+		action(s.smCtx, eventCtx)
+		s.leaveSite()
+	}
+	if _, isChoice := s.pseudo[nextStateID]; isChoice {
+		nextStateID, history = s.resolveHistory(s.selectChoice(nextStateID, eventCtx))
+	}
+	s.setCurrent(nextStateID)
 
-	return s.ChangeState(nextStateID)
+	entered := leafSet[StateIdentifier]{}
+	if history.active {
+		s.extra().entering = history
+	}
+	s.enter(ctx, lca, hasLCA, nextStateID, &entered)
+	if history.active {
+		s.extra().entering = historyEntry[StateIdentifier]{}
+	}
+	if hasLCA && leaves != nil {
+		// regions outside the transition domain stay active
+		entered = s.mergeLeaves(leaves, lca, entered)
+	}
+	s.setConfiguration(entered)
+	s.markChanged()
+	if s.hasDeferred {
+		s.extra().transitions++
+	}
+	s.notify(ctx, hookAfterTransition, prevStateID, nextStateID, eventCtx, nil)
+
+	return s.complete(ctx, eventCtx)
 }
 
-func (s *stateMachine[StateIdentifier]) ChangeState(nextStateID StateIdentifier) error {
-	currentState := s.states[s.currentStateID]
-	_, canSwitch := currentState.transitions[nextStateID]
-	if !canSwitch {
-		return fmt.Errorf("cannot switch from %v to %v: %w", s.currentStateID, nextStateID, ErrNoValidTransition)
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Reset() error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	if err := s.checkRunning(); err != nil {
+		return err
 	}
-	s.currentStateID = nextStateID
-	currentState.action.OnExit(s.smCtx)
-	nextState := s.states[nextStateID]
-	nextState.action.OnEnter(s.smCtx)
 
-	return nil
+	var noState StateIdentifier
+	var noEvent Event
+	return s.applyLogged(context.Background(), RecordReset, noState, noEvent)
 }
 
-func (s *stateMachine[StateIdentifier]) Reset() {
-	currentState := s.states[s.currentStateID]
-	currentState.action.OnExit(s.smCtx)
+// reset exits all the active states and enters the default state.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) reset(ctx context.Context) error {
+	prevStateID := s.currentStateID
+	s.exit(ctx, s.currentStateID, s.activeLeaves, s.currentStateID, false)
+	s.unfinish()
+	if x := s.extras.Load(); x != nil {
+		x.history = nil
+		x.deferred = nil
+	}
+	s.setCurrent(s.defaultStateID)
 
-	defaultState := s.states[s.defaultStateID]
-	defaultState.action.OnEnter(s.smCtx)
+	entered := leafSet[StateIdentifier]{}
+	s.enter(ctx, s.defaultStateID, false, s.defaultStateID, &entered)
+	s.setConfiguration(entered)
 
-	s.currentStateID = s.defaultStateID
+	var noEvent Event
+	s.notify(ctx, hookReset, prevStateID, s.defaultStateID, noEvent, nil)
+	return s.complete(ctx, noEvent)
+}
+
+// canSwitch checks if nextStateID is listed in transitions of sourceID or any of its ancestors.
+func (d *definition[StateIdentifier, Event, SmContext]) canSwitch(sourceID, nextStateID StateIdentifier) bool {
+	for stateID, ok := sourceID, true; ok; stateID, ok = d.parentOf(stateID) {
+		if _, found := d.states[stateID].transitions[nextStateID]; found {
+			return true
+		}
+	}
+	return false
 }
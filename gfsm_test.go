@@ -80,30 +80,32 @@ type aContext struct {
 }
 
 func newSmManual(t *testing.T) StateMachineHandler[StartStopSM] {
-	return &stateMachine[StartStopSM]{
-		currentStateID: Start,
-		states: StatesMap[StartStopSM]{
-			Start: state[StartStopSM]{
-				action: &StartState{},
-				transitions: Transitions[StartStopSM]{
-					Stop:       struct{}{},
-					InProgress: struct{}{},
+	return &stateMachine[StartStopSM, EventContext, StateMachineContext]{
+		definition: &definition[StartStopSM, EventContext, StateMachineContext]{
+			states: StatesMap[StartStopSM]{
+				Start: state[StartStopSM, EventContext, StateMachineContext]{
+					action: &StartState{},
+					transitions: Transitions[StartStopSM]{
+						Stop:       struct{}{},
+						InProgress: struct{}{},
+					},
 				},
-			},
-			Stop: state[StartStopSM]{
-				action: &StopState{},
-				transitions: Transitions[StartStopSM]{
-					Start: struct{}{},
+				Stop: state[StartStopSM, EventContext, StateMachineContext]{
+					action: &StopState{},
+					transitions: Transitions[StartStopSM]{
+						Start: struct{}{},
+					},
 				},
-			},
-			InProgress: state[StartStopSM]{
-				action: &InProgressState{},
-				transitions: Transitions[StartStopSM]{
-					Stop: struct{}{},
+				InProgress: state[StartStopSM, EventContext, StateMachineContext]{
+					action: &InProgressState{},
+					transitions: Transitions[StartStopSM]{
+						Stop: struct{}{},
+					},
 				},
 			},
 		},
-		smCtx: &aContext{t: t},
+		currentStateID: Start,
+		smCtx:          &aContext{t: t},
 	}
 }
 
@@ -147,11 +149,17 @@ func TestStateMachine(t *testing.T) {
 
 func TestDoubleStateCreation(t *testing.T) {
 	builder := NewBuilder[StartStopSM]().
+		SetDefaultState(Stop).
 		RegisterState(Stop, &StopState{}, []StartStopSM{Stop})
 
-	assert.Panics(t, func() {
+	assert.NotPanics(t, func() {
 		builder.RegisterState(Stop, &StopState{}, []StartStopSM{Stop})
 	})
+	assert.Panics(t, func() {
+		builder.Build()
+	})
+	_, err := builder.BuildE()
+	assert.ErrorIs(t, err, ErrDuplicateState)
 }
 
 func TestResetStatMachine(t *testing.T) {
@@ -0,0 +1,142 @@
+package gfsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ConnSM int
+
+const (
+	Disconnected ConnSM = iota
+	Connected
+	Idle
+	Busy
+	Draining
+)
+
+type connEvent string
+
+// recordingState logs each callback into the shared journal and routes events using the routes table.
+type recordingState[StateIdentifier comparable] struct {
+	id      StateIdentifier
+	journal *[]string
+	routes  map[connEvent]StateIdentifier
+}
+
+func (s *recordingState[StateIdentifier]) OnEnter(_ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("enter %v", s.id))
+}
+
+func (s *recordingState[StateIdentifier]) OnExit(_ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("exit %v", s.id))
+}
+
+func (s *recordingState[StateIdentifier]) Execute(_ StateMachineContext, eventCtx EventContext) StateIdentifier {
+	*s.journal = append(*s.journal, fmt.Sprintf("execute %v", s.id))
+	event, _ := eventCtx.(connEvent)
+	if next, ok := s.routes[event]; ok {
+		return next
+	}
+	return s.id
+}
+
+func newConnSM(journal *[]string) StateMachineHandler[ConnSM] {
+	return newConnBuilder(journal).Build()
+}
+
+func newConnBuilder(journal *[]string) StateMachineBuilder[ConnSM] {
+	state := func(id ConnSM, routes map[connEvent]ConnSM) *recordingState[ConnSM] {
+		return &recordingState[ConnSM]{id: id, journal: journal, routes: routes}
+	}
+	return NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		RegisterState(Disconnected, state(Disconnected, map[connEvent]ConnSM{"connect": Connected}), []ConnSM{Connected}).
+		RegisterState(Connected, state(Connected, map[connEvent]ConnSM{"drop": Disconnected}), []ConnSM{Disconnected}).
+		RegisterSubState(Connected, Idle, state(Idle, map[connEvent]ConnSM{"work": Busy}), []ConnSM{Busy}).
+		RegisterSubState(Connected, Busy, state(Busy, map[connEvent]ConnSM{"done": Idle, "drain": Draining}), []ConnSM{Idle, Draining}).
+		RegisterSubState(Connected, Draining, state(Draining, map[connEvent]ConnSM{"restart": Connected}), []ConnSM{Connected}).
+		SetDefaultSubState(Connected, Idle)
+}
+
+func TestHierarchicalTransitions(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+
+	sm.Start()
+	assert.Equal(t, Disconnected, sm.State())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, Idle, sm.State())
+	assert.Equal(t, []string{"enter 0", "execute 0", "exit 0", "enter 1", "enter 2"}, journal)
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, Busy, sm.State())
+	assert.Equal(t, []string{"execute 2", "exit 2", "enter 3"}, journal)
+
+	// unhandled by Busy, handled by Connected
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("drop")))
+	assert.Equal(t, Disconnected, sm.State())
+	assert.Equal(t, []string{"execute 3", "execute 1", "exit 3", "exit 1", "enter 0"}, journal)
+
+	sm.Stop()
+}
+
+func TestHierarchicalTransitionToParent(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("drain")))
+	assert.Equal(t, Draining, sm.State())
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("restart")))
+	assert.Equal(t, Idle, sm.State())
+	assert.Equal(t, []string{"execute 4", "exit 4", "exit 1", "enter 1", "enter 2"}, journal)
+
+	// ignored by every state in the hierarchy
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("unknown")))
+	assert.Equal(t, Idle, sm.State())
+	assert.Equal(t, []string{"execute 2", "execute 1"}, journal)
+
+	journal = nil
+	sm.Reset()
+	assert.Equal(t, Disconnected, sm.State())
+	assert.Equal(t, []string{"exit 2", "exit 1", "enter 0"}, journal)
+}
+
+func TestHierarchicalInvalidTransition(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	// Idle does not list Draining, neither does Connected
+	err := sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).ChangeState(Draining)
+	assert.ErrorIs(t, err, ErrNoValidTransition)
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestHierarchicalBuilderErrors(t *testing.T) {
+	assert.Panics(t, func() {
+		NewBuilder[ConnSM]().
+			SetDefaultState(Connected).
+			RegisterState(Connected, &recordingState[ConnSM]{}, nil).
+			RegisterSubState(Connected, Idle, &recordingState[ConnSM]{}, nil).
+			Build()
+	}, "no default sub-state")
+	assert.Panics(t, func() {
+		NewBuilder[ConnSM]().
+			SetDefaultState(Idle).
+			RegisterSubState(Connected, Idle, &recordingState[ConnSM]{}, nil).
+			Build()
+	}, "parent is not registered")
+}
@@ -0,0 +1,78 @@
+package gfsm
+
+import "fmt"
+
+// historyEntry is the history pseudo-state being entered by a transition.
+type historyEntry[StateIdentifier comparable] struct {
+	parentID StateIdentifier
+	deep     bool
+	active   bool
+}
+
+// HistoryEntry is the last active sub-state of a composite state saved in Snapshot.
+type HistoryEntry[StateIdentifier comparable] struct {
+	State    StateIdentifier `json:"state"`
+	SubState StateIdentifier `json:"sub_state"`
+}
+
+// remember saves the exited stateID as the last active sub-state of its parent, if the state machine has history
+// pseudo-states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) remember(stateID StateIdentifier) {
+	if !s.hasHistory {
+		return
+	}
+	parentID, ok := s.parentOf(stateID)
+	if !ok || s.states[parentID].parallel {
+		return
+	}
+	x := s.extra()
+	if x.history == nil {
+		x.history = map[StateIdentifier]StateIdentifier{}
+	}
+	x.history[parentID] = stateID
+}
+
+// subStateToEnter returns the sub-state entered together with the composite (not parallel) state stateID: the one
+// saved in history while a history pseudo-state is entered, or the default one.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) subStateToEnter(stateID StateIdentifier) StateIdentifier {
+	x := s.extras.Load()
+	if x == nil {
+		return s.states[stateID].defaultSubState
+	}
+	if h := x.entering; h.active && (stateID == h.parentID || (h.deep && s.isAncestor(h.parentID, stateID))) {
+		if subStateID, ok := x.history[stateID]; ok {
+			return subStateID
+		}
+	}
+	return s.states[stateID].defaultSubState
+}
+
+// historyEntries returns the saved history in registration order of the composite states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) historyEntries() []HistoryEntry[StateIdentifier] {
+	x := s.extras.Load()
+	if x == nil {
+		return nil
+	}
+	var entries []HistoryEntry[StateIdentifier]
+	for _, stateID := range s.order {
+		if subStateID, ok := x.history[stateID]; ok {
+			entries = append(entries, HistoryEntry[StateIdentifier]{State: stateID, SubState: subStateID})
+		}
+	}
+	return entries
+}
+
+// checkHistory verifies that each history entry refers to a composite state and its sub-state.
+func (d *definition[StateIdentifier, Event, SmContext]) checkHistory(entries []HistoryEntry[StateIdentifier]) error {
+	for _, entry := range entries {
+		st, ok := d.states[entry.State]
+		if !ok || !st.composite || st.parallel {
+			return fmt.Errorf("history state %v is not a composite state: %w", entry.State, ErrInvalidSnapshot)
+		}
+		if parentID, ok := d.parentOf(entry.SubState); !ok || parentID != entry.State {
+			return fmt.Errorf("history sub-state %v is not a sub-state of %v: %w", entry.SubState, entry.State,
+				ErrInvalidSnapshot)
+		}
+	}
+	return nil
+}
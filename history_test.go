@@ -0,0 +1,142 @@
+package gfsm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type UploadSM int
+
+const (
+	Paused UploadSM = iota
+	Transfer
+	Hashing
+	Uploading
+	Chunking
+	Committing
+	Verifying
+	TransferHistory
+	TransferDeepHistory
+)
+
+func newUploadBuilder(journal *[]string) StateMachineBuilder[UploadSM] {
+	state := func(id UploadSM, routes map[connEvent]UploadSM) *recordingState[UploadSM] {
+		return &recordingState[UploadSM]{id: id, journal: journal, routes: routes}
+	}
+	return NewBuilder[UploadSM]().
+		SetDefaultState(Paused).
+		RegisterState(Paused, state(Paused, map[connEvent]UploadSM{
+			"resume":      TransferHistory,
+			"resume deep": TransferDeepHistory,
+			"restart":     Transfer,
+		}), []UploadSM{TransferHistory, TransferDeepHistory, Transfer}).
+		RegisterState(Transfer, state(Transfer, map[connEvent]UploadSM{"pause": Paused}), []UploadSM{Paused}).
+		RegisterSubState(Transfer, Hashing, state(Hashing, map[connEvent]UploadSM{"hashed": Uploading}), []UploadSM{Uploading}).
+		RegisterSubState(Transfer, Uploading, state(Uploading, nil), nil).
+		RegisterSubState(Uploading, Chunking, state(Chunking, map[connEvent]UploadSM{"chunked": Committing}), []UploadSM{Committing}).
+		RegisterSubState(Uploading, Committing, state(Committing, map[connEvent]UploadSM{"committed": Verifying}), []UploadSM{Verifying}).
+		RegisterSubState(Transfer, Verifying, state(Verifying, nil), nil).
+		SetDefaultSubState(Transfer, Hashing).
+		SetDefaultSubState(Uploading, Chunking).
+		SetFinalState(Verifying).
+		RegisterHistoryState(Transfer, TransferHistory, ShallowHistory).
+		RegisterHistoryState(Transfer, TransferDeepHistory, DeepHistory)
+}
+
+// pauseCommitting moves the upload to Committing and pauses it.
+func pauseCommitting(t *testing.T, sm StateMachineHandler[UploadSM]) {
+	for _, ev := range []connEvent{"restart", "hashed", "chunked", "pause"} {
+		assert.NoError(t, sm.ProcessEvent(ev))
+	}
+	assert.Equal(t, Paused, sm.State())
+}
+
+func TestShallowHistory(t *testing.T) {
+	var journal []string
+	sm, err := newUploadBuilder(&journal).BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	// nothing is saved before the first exit
+	assert.NoError(t, sm.ProcessEvent(connEvent("resume")))
+	assert.Equal(t, Hashing, sm.State())
+	assert.NoError(t, sm.ProcessEvent(connEvent("pause")))
+
+	pauseCommitting(t, sm)
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("resume")))
+	assert.Equal(t, Chunking, sm.State())
+	assert.Equal(t, []string{"execute 0", "exit 0", "enter 1", "enter 3", "enter 4"}, journal)
+}
+
+func TestDeepHistory(t *testing.T) {
+	var journal []string
+	var transitions []TransitionInfo[UploadSM]
+	sm := newUploadBuilder(&journal).
+		AddListener(Listener[UploadSM]{
+			AfterTransition: func(_ context.Context, info TransitionInfo[UploadSM]) {
+				transitions = append(transitions, info)
+			},
+		}).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	pauseCommitting(t, sm)
+	journal = nil
+	transitions = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("resume deep")))
+	assert.Equal(t, Committing, sm.State())
+	assert.Equal(t, []string{"execute 0", "exit 0", "enter 1", "enter 3", "enter 5"}, journal)
+	// listeners see the state entered instead of the pseudo-state
+	assert.Equal(t, Transfer, transitions[0].Target)
+
+	assert.NoError(t, sm.Reset())
+	assert.NoError(t, sm.ProcessEvent(connEvent("resume deep")))
+	assert.Equal(t, Hashing, sm.State())
+}
+
+func TestHistorySnapshot(t *testing.T) {
+	var journal []string
+	sm := newUploadBuilder(&journal).Build()
+	assert.NoError(t, sm.Start())
+	pauseCommitting(t, sm)
+
+	snapshot, err := sm.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryEntry[UploadSM]{
+		{State: Transfer, SubState: Uploading},
+		{State: Uploading, SubState: Committing},
+	}, snapshot.History)
+
+	restored, err := newUploadBuilder(&journal).Restore(snapshot, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.ProcessEvent(connEvent("resume deep")))
+	assert.Equal(t, Committing, restored.State())
+
+	snapshot.History = []HistoryEntry[UploadSM]{{State: Transfer, SubState: Committing}}
+	_, err = newUploadBuilder(&journal).Restore(snapshot, RestoreSkipOnEnter)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+}
+
+func TestHistoryValidation(t *testing.T) {
+	var journal []string
+	_, err := newUploadBuilder(&journal).
+		RegisterHistoryState(Paused, 100, ShallowHistory).
+		BuildE()
+	assert.ErrorIs(t, err, ErrInvalidHierarchy)
+
+	_, err = newUploadBuilder(&journal).
+		RegisterHistoryState(Transfer, Hashing, DeepHistory).
+		BuildE()
+	assert.ErrorIs(t, err, ErrDuplicateState)
+
+	desc := newUploadBuilder(&journal).Describe()
+	assert.Equal(t, []PseudoStateDescription[UploadSM]{
+		{ID: TransferHistory, Kind: ShallowHistory, Parent: Transfer},
+		{ID: TransferDeepHistory, Kind: DeepHistory, Parent: Transfer},
+	}, desc.PseudoStates)
+}
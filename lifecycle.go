@@ -0,0 +1,61 @@
+package gfsm
+
+import "fmt"
+
+var (
+	// ErrNotStarted is returned by ProcessEvent, Stop, Reset and ChangeState calls made before Start.
+	ErrNotStarted = fmt.Errorf("state machine is not started")
+	// ErrAlreadyStarted is returned by Start of a running state machine.
+	ErrAlreadyStarted = fmt.Errorf("state machine is already started")
+)
+
+// Status is the lifecycle phase of a state machine returned by StateMachineHandler.Status.
+type Status int
+
+const (
+	// StatusCreated is the status of a state machine which has not been started yet.
+	StatusCreated Status = iota
+	// StatusRunning is the status between Start and Stop calls. State machines created with Restore and Replay are
+	// running from the beginning.
+	StatusRunning
+	// StatusStopped is the final status after Stop call, a stopped state machine cannot be started again.
+	StatusStopped
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusCreated:
+		return "Created"
+	case StatusRunning:
+		return "Running"
+	case StatusStopped:
+		return "Stopped"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Status() Status {
+	s.rlockState()
+	defer s.runlockState()
+	return s.status
+}
+
+// setStatus updates the lifecycle status, it must be called under the events lock.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) setStatus(status Status) {
+	s.lockState()
+	defer s.unlockState()
+	s.status = status
+}
+
+// checkRunning returns an error if the state machine is not running, it must be called under the events lock.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) checkRunning() error {
+	switch s.status {
+	case StatusCreated:
+		return ErrNotStarted
+	case StatusStopped:
+		return ErrStopped
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,57 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+	assert.Equal(t, StatusCreated, sm.Status())
+
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("connect")), ErrNotStarted)
+	assert.ErrorIs(t, sm.Reset(), ErrNotStarted)
+	assert.ErrorIs(t, sm.Stop(), ErrNotStarted)
+	assert.Empty(t, journal)
+
+	assert.NoError(t, sm.Start())
+	assert.Equal(t, StatusRunning, sm.Status())
+	assert.ErrorIs(t, sm.Start(), ErrAlreadyStarted)
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.Reset())
+
+	journal = nil
+	assert.NoError(t, sm.Stop())
+	assert.Equal(t, StatusStopped, sm.Status())
+	assert.ErrorIs(t, sm.Stop(), ErrStopped)
+	assert.ErrorIs(t, sm.Reset(), ErrStopped)
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("connect")), ErrStopped)
+	// OnExit is called once
+	assert.Equal(t, []string{"exit 0"}, journal)
+
+	// a stopped state machine cannot be started again
+	journal = nil
+	assert.ErrorIs(t, sm.Start(), ErrStopped)
+	assert.Equal(t, StatusStopped, sm.Status())
+	assert.Empty(t, journal)
+	assert.Equal(t, "Stopped", StatusStopped.String())
+}
+
+func TestLifecycleMailbox(t *testing.T) {
+	var journal []string
+	sm := newConnBuilder(&journal).
+		SetMailbox(4, OverflowBlock).
+		Build()
+
+	// posted events wait for Start, while ProcessEvent is rejected
+	assert.NoError(t, sm.Post(connEvent("connect")))
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("work")), ErrNotStarted)
+
+	assert.NoError(t, sm.Start())
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, Busy, sm.State())
+	assert.NoError(t, sm.Stop())
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("done")), ErrStopped)
+}
@@ -0,0 +1,140 @@
+package gfsm
+
+import "context"
+
+// TypedTransitionInfo describes a state machine activity reported to listeners.
+type TypedTransitionInfo[StateIdentifier comparable, Event any] struct {
+	// Name is the state machine name set by StateMachineBuilder.SetSMName.
+	Name string
+	// Source is the state reported by State() before the activity. It is not set for Start.
+	Source StateIdentifier
+	// Target is the requested state. It is not set for Stop, and is equal to Source for ignored events.
+	Target StateIdentifier
+	// Event is the processed event, it is not set for Start, Stop, Reset and ChangeState calls.
+	Event Event
+	// Err is the reason of a rejected transition, or the error of a failed timeout.
+	Err error
+}
+
+// TransitionInfo is the TypedTransitionInfo for any EventContext.
+type TransitionInfo[StateIdentifier comparable] = TypedTransitionInfo[StateIdentifier, EventContext]
+
+// TypedListener observes a state machine activity. All callbacks are optional, and are called synchronously from
+// the state machine calls, with the same context.Context as TypedContextAction callbacks receive. Listeners follow
+// the same rules as state callbacks do, so they must not call Start, Stop, Reset or ProcessEvent of a thread-safe
+// state machine they observe.
+type TypedListener[StateIdentifier comparable, Event any] struct {
+	// BeforeTransition is called before the first OnExit of the transition.
+	BeforeTransition func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// AfterTransition is called after the last OnEnter of the transition.
+	AfterTransition func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// TransitionRejected is called if the target is not listed in the allowed transitions, Err is
+	// ErrNoValidTransition in this case.
+	TransitionRejected func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// EventIgnored is called if none of the active states handled the event.
+	EventIgnored func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// Started is called after the default state is entered on Start.
+	Started func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// Stopped is called after all active states are exited on Stop.
+	Stopped func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// Reset is called after the default state is entered on Reset.
+	Reset func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// Finished is called after the state machine has reached a final state, Source and Target are the final state.
+	Finished func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+	// TimeoutFailed is called if a state timeout returned an error, as there is no caller to return it to. Source is
+	// the timed out state, Target is the state after the timeout, Event is the timeout event, if any, and Err is
+	// the error, for example *PanicError, *DeferredEventError or a store failure.
+	TimeoutFailed func(ctx context.Context, info TypedTransitionInfo[StateIdentifier, Event])
+}
+
+// Listener is the TypedListener for any EventContext.
+type Listener[StateIdentifier comparable] = TypedListener[StateIdentifier, EventContext]
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Subscribe(listener TypedListener[StateIdentifier, Event]) func() {
+	s.lockEvents()
+	defer s.unlockEvents()
+
+	subscribed := &listener
+	x := s.extra()
+	if !x.subscribed {
+		x.listeners, x.subscribed = s.definition.listeners, true
+	}
+	// the listeners can be shared with the definition, so they are always copied
+	x.listeners = append(x.listeners[:len(x.listeners):len(x.listeners)], subscribed)
+
+	return func() {
+		s.lockEvents()
+		defer s.unlockEvents()
+
+		for i, l := range x.listeners {
+			if l == subscribed {
+				// copy the slice, so notifications in progress are not affected
+				x.listeners = append(x.listeners[:i:i], x.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// hook selects a TypedListener callback.
+type hook int
+
+const (
+	hookBeforeTransition hook = iota
+	hookAfterTransition
+	hookTransitionRejected
+	hookEventIgnored
+	hookStarted
+	hookStopped
+	hookReset
+	hookFinished
+	hookTimeoutFailed
+)
+
+func (l *TypedListener[StateIdentifier, Event]) callback(h hook) func(context.Context, TypedTransitionInfo[StateIdentifier, Event]) {
+	switch h {
+	case hookBeforeTransition:
+		return l.BeforeTransition
+	case hookAfterTransition:
+		return l.AfterTransition
+	case hookTransitionRejected:
+		return l.TransitionRejected
+	case hookEventIgnored:
+		return l.EventIgnored
+	case hookStarted:
+		return l.Started
+	case hookStopped:
+		return l.Stopped
+	case hookFinished:
+		return l.Finished
+	case hookTimeoutFailed:
+		return l.TimeoutFailed
+	default:
+		return l.Reset
+	}
+}
+
+// notify calls the h callback of each listener having it.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) notify(
+	ctx context.Context,
+	h hook,
+	source, target StateIdentifier,
+	eventCtx Event,
+	err error) {
+
+	listeners := s.definition.listeners
+	if x := s.extras.Load(); x != nil && x.subscribed {
+		listeners = x.listeners
+	}
+	if len(listeners) == 0 {
+		return
+	}
+	info := TypedTransitionInfo[StateIdentifier, Event]{Name: s.name, Source: source, Target: target, Event: eventCtx, Err: err}
+	for _, l := range listeners {
+		if callback := l.callback(h); callback != nil {
+			s.enterSite(s.currentStateID, PhaseListener)
+			callback(ctx, info)
+			s.leaveSite()
+		}
+	}
+}
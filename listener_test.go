@@ -0,0 +1,95 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// journalListener logs every notification into the journal.
+func journalListener(journal *[]string) Listener[ConnSM] {
+	record := func(kind string) func(context.Context, TransitionInfo[ConnSM]) {
+		return func(_ context.Context, info TransitionInfo[ConnSM]) {
+			*journal = append(*journal, fmt.Sprintf("%s %s %v->%v %v", kind, info.Name, info.Source, info.Target, info.Event))
+		}
+	}
+	return Listener[ConnSM]{
+		BeforeTransition:   record("before"),
+		AfterTransition:    record("after"),
+		TransitionRejected: record("rejected"),
+		EventIgnored:       record("ignored"),
+		Started:            record("started"),
+		Stopped:            record("stopped"),
+		Reset:              record("reset"),
+	}
+}
+
+func TestListenerNotifications(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+	unsubscribe := sm.Subscribe(journalListener(&journal))
+
+	sm.Start()
+	assert.Equal(t, []string{"enter 0", "started  0->0 <nil>"}, journal)
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, []string{
+		"execute 0",
+		"before  0->1 connect",
+		"exit 0",
+		"enter 1",
+		"enter 2",
+		"after  0->1 connect",
+	}, journal)
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("unknown")))
+	assert.Equal(t, []string{"execute 2", "execute 1", "ignored  2->2 unknown"}, journal)
+
+	journal = nil
+	err := sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).ChangeState(Draining)
+	assert.ErrorIs(t, err, ErrNoValidTransition)
+	assert.Equal(t, []string{"rejected  2->4 <nil>"}, journal)
+
+	journal = nil
+	sm.Reset()
+	assert.Equal(t, []string{"exit 2", "exit 1", "enter 0", "reset  2->0 <nil>"}, journal)
+
+	unsubscribe()
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, []string{"execute 0", "exit 0", "enter 1", "enter 2"}, journal)
+
+	sm.Subscribe(journalListener(&journal))
+	journal = nil
+	sm.Stop()
+	assert.Equal(t, []string{"exit 2", "exit 1", "stopped  2->0 <nil>"}, journal)
+}
+
+func TestBuilderListeners(t *testing.T) {
+	var journal []string
+	sm := NewBuilder[ConnSM]().
+		SetSMName("conn").
+		SetDefaultState(Idle).
+		RegisterState(Idle, &recordingState[ConnSM]{id: Idle, journal: &journal, routes: map[connEvent]ConnSM{"work": Busy}}, []ConnSM{Busy}).
+		RegisterState(Busy, &recordingState[ConnSM]{id: Busy, journal: &journal}, nil).
+		AddListener(Listener[ConnSM]{
+			AfterTransition: func(_ context.Context, info TransitionInfo[ConnSM]) {
+				journal = append(journal, fmt.Sprintf("first %s %v->%v", info.Name, info.Source, info.Target))
+			},
+		}).
+		AddListener(Listener[ConnSM]{
+			AfterTransition: func(_ context.Context, info TransitionInfo[ConnSM]) {
+				journal = append(journal, fmt.Sprintf("second %s %v->%v", info.Name, info.Source, info.Target))
+			},
+		}).
+		Build()
+	sm.Start()
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, []string{"execute 2", "exit 2", "enter 3", "first conn 2->3", "second conn 2->3"}, journal)
+}
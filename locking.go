@@ -0,0 +1,49 @@
+package gfsm
+
+import "sync"
+
+// machineLock makes a state machine safe for concurrent use. It is created by the builder for state machines
+// configured with StateMachineBuilder.SetThreadSafe(true), non thread-safe state machines have no lock at all.
+type machineLock struct {
+	// events serializes Start, Stop, Reset and ProcessEvent calls, including all state callbacks.
+	events sync.Mutex
+	// state guards the active configuration, so State and ActiveStates can be called from any goroutine,
+	// including state callbacks executed under the events lock.
+	state sync.RWMutex
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) lockEvents() {
+	if s.lock != nil {
+		s.lock.events.Lock()
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) unlockEvents() {
+	if s.lock != nil {
+		s.lock.events.Unlock()
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) lockState() {
+	if s.lock != nil {
+		s.lock.state.Lock()
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) unlockState() {
+	if s.lock != nil {
+		s.lock.state.Unlock()
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) rlockState() {
+	if s.lock != nil {
+		s.lock.state.RLock()
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) runlockState() {
+	if s.lock != nil {
+		s.lock.state.RUnlock()
+	}
+}
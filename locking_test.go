@@ -0,0 +1,130 @@
+package gfsm
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pingPongState switches between Start and Stop on each event and checks that State is available from callbacks.
+type pingPongState struct {
+	next StartStopSM
+	sm   *StateMachineHandler[StartStopSM]
+	hits *int
+}
+
+func (s *pingPongState) OnEnter(_ StateMachineContext) {
+	_ = (*s.sm).State()
+}
+
+func (s *pingPongState) OnExit(_ StateMachineContext) {
+}
+
+func (s *pingPongState) Execute(_ StateMachineContext, _ EventContext) StartStopSM {
+	*s.hits++
+	return s.next
+}
+
+func TestThreadSafeStateMachine(t *testing.T) {
+	var sm StateMachineHandler[StartStopSM]
+	hits := 0
+	sm = NewBuilder[StartStopSM]().
+		SetThreadSafe(true).
+		SetDefaultState(Start).
+		RegisterState(Start, &pingPongState{next: Stop, sm: &sm, hits: &hits}, []StartStopSM{Stop}).
+		RegisterState(Stop, &pingPongState{next: Start, sm: &sm, hits: &hits}, []StartStopSM{Start}).
+		Build()
+	sm.Start()
+
+	const workers, events = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < events; j++ {
+				assert.NoError(t, sm.ProcessEvent(StartData{}))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < events; j++ {
+				state := sm.State()
+				assert.True(t, state == Start || state == Stop)
+				assert.Len(t, sm.ActiveStates(), 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, workers*events, hits)
+	// even number of switches brings the state machine back to the default state
+	assert.Equal(t, Start, sm.State())
+
+	sm.Stop()
+	assert.ErrorIs(t, sm.ProcessEvent(StartData{}), ErrStopped)
+	assert.Equal(t, workers*events, hits)
+}
+
+func TestThreadSafeStopRejectsPendingEvents(t *testing.T) {
+	var sm StateMachineHandler[StartStopSM]
+	hits := 0
+	sm = NewBuilder[StartStopSM]().
+		SetThreadSafe(true).
+		SetDefaultState(Start).
+		RegisterState(Start, &pingPongState{next: Start, sm: &sm, hits: &hits}, nil).
+		Build()
+	sm.Start()
+
+	var wg sync.WaitGroup
+	results := make(chan error, 100)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- sm.ProcessEvent(StartData{})
+		}()
+	}
+	sm.Stop()
+	wg.Wait()
+	close(results)
+
+	processed := 0
+	for err := range results {
+		if err == nil {
+			processed++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrStopped)
+	}
+	// every event is either processed before Stop or rejected after it
+	assert.Equal(t, processed, hits)
+}
+
+// exitPanickingState panics in OnExit.
+type exitPanickingState struct {
+	pingPongState
+}
+
+func (s *exitPanickingState) OnExit(_ StateMachineContext) {
+	panic("exit failure")
+}
+
+func TestThreadSafeStopPanic(t *testing.T) {
+	var sm StateMachineHandler[StartStopSM]
+	hits := 0
+	sm = NewBuilder[StartStopSM]().
+		SetThreadSafe(true).
+		SetDefaultState(Start).
+		RegisterState(Start, &exitPanickingState{pingPongState{next: Start, sm: &sm, hits: &hits}}, nil).
+		Build()
+	assert.NoError(t, sm.Start())
+
+	assert.Panics(t, func() {
+		_ = sm.Stop()
+	})
+	// the events lock is released by the panicking Stop
+	assert.ErrorIs(t, sm.ProcessEvent(StartData{}), ErrStopped)
+	assert.Zero(t, hits)
+}
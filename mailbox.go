@@ -0,0 +1,212 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+)
+
+var (
+	// ErrNoMailbox is returned by Post and Send of state machines built without StateMachineBuilder.SetMailbox.
+	ErrNoMailbox = fmt.Errorf("state machine has no mailbox")
+	// ErrQueueFull is returned by Post and Send if the mailbox is full and OverflowFail policy is used. Send calls
+	// for events dropped by OverflowDropNewest and OverflowDropOldest policies return it as well.
+	ErrQueueFull = fmt.Errorf("mailbox is full")
+)
+
+// OverflowPolicy defines how Post and Send behave when the mailbox is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the mailbox has free space. Note that a state callback posting into the full
+	// mailbox of its own state machine will wait forever, as the callback blocks the mailbox processing.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the event being posted. Post does not report it, while Send returns ErrQueueFull.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest event in the mailbox to free space for the new one. If the dropped event
+	// was delivered with Send, the call returns ErrQueueFull.
+	OverflowDropOldest
+	// OverflowFail rejects the event being posted with ErrQueueFull.
+	OverflowFail
+)
+
+type mailboxItem[Event any] struct {
+	ctx      context.Context
+	eventCtx Event
+	// result receives ProcessEvent result for events delivered with Send, it is nil for Post.
+	result chan error
+}
+
+// mailbox is the bounded events queue processed by a dedicated goroutine, which is running between Start and Stop.
+// Events posted before Start are kept in the queue until the state machine is started.
+type mailbox[Event any] struct {
+	policy OverflowPolicy
+
+	queue chan mailboxItem[Event]
+	// done is closed by Stop to terminate the processing goroutine, and finished is closed by the goroutine on exit.
+	done     chan struct{}
+	finished chan struct{}
+	// running is set between Start and Stop calls
+	running bool
+}
+
+// newMailbox creates the mailbox. Its channels are never replaced, as a stopped state machine cannot be started again,
+// so they are safe to use without the events lock.
+func newMailbox[Event any](capacity int, policy OverflowPolicy) *mailbox[Event] {
+	return &mailbox[Event]{
+		policy:   policy,
+		queue:    make(chan mailboxItem[Event], capacity),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// push adds the item to the queue according to the overflow policy.
+func (m *mailbox[Event]) push(ctx context.Context, item mailboxItem[Event]) error {
+	select {
+	case <-m.done:
+		return ErrStopped
+	default:
+	}
+
+	switch m.policy {
+	case OverflowDropNewest:
+		select {
+		case m.queue <- item:
+		default:
+			item.reply(ErrQueueFull)
+		}
+		return nil
+	case OverflowDropOldest:
+		for {
+			select {
+			case m.queue <- item:
+				return nil
+			default:
+			}
+			select {
+			case dropped := <-m.queue:
+				dropped.reply(ErrQueueFull)
+			default:
+			}
+		}
+	case OverflowFail:
+		select {
+		case m.queue <- item:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	default:
+		select {
+		case m.queue <- item:
+			return nil
+		case <-m.done:
+			return ErrStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drain rejects all events left in the stopped mailbox.
+func (m *mailbox[Event]) drain() {
+	for {
+		select {
+		case item := <-m.queue:
+			item.reply(ErrStopped)
+		default:
+			return
+		}
+	}
+}
+
+func (i mailboxItem[Event]) reply(err error) {
+	if i.result != nil {
+		i.result <- err
+	}
+}
+
+// run processes the mailbox until Stop call.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) run(m *mailbox[Event]) {
+	defer close(m.finished)
+	for {
+		select {
+		case item := <-m.queue:
+			item.reply(s.handleEvent(item.ctx, item.eventCtx))
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Post(eventCtx Event) error {
+	m := s.eventsMailbox()
+	if m == nil {
+		return ErrNoMailbox
+	}
+	return m.push(context.Background(), mailboxItem[Event]{ctx: context.Background(), eventCtx: eventCtx})
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Send(ctx context.Context, eventCtx Event) error {
+	m := s.eventsMailbox()
+	if m == nil {
+		return ErrNoMailbox
+	}
+	item := mailboxItem[Event]{ctx: ctx, eventCtx: eventCtx, result: make(chan error, 1)}
+	if err := m.push(ctx, item); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.finished:
+		// the state machine was stopped, but the event might be processed before that
+		select {
+		case err := <-item.result:
+			return err
+		default:
+			return ErrStopped
+		}
+	}
+}
+
+// eventsMailbox returns the mailbox of a state machine processing events asynchronously, or nil. It can be called
+// without locks, as the mailbox is set on the instance creation.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) eventsMailbox() *mailbox[Event] {
+	if !s.hasMailbox {
+		return nil
+	}
+	return s.extras.Load().mailbox
+}
+
+// startMailbox launches the mailbox processing goroutine, it must be called under the events lock.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) startMailbox() {
+	m := s.eventsMailbox()
+	if m == nil || m.running {
+		return
+	}
+	m.running = true
+	go s.run(m)
+}
+
+// releaseMailbox marks the mailbox as not running and returns it if the processing goroutine has to be stopped.
+// It must be called under the events lock.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) releaseMailbox() *mailbox[Event] {
+	m := s.eventsMailbox()
+	if m == nil || !m.running {
+		return nil
+	}
+	m.running = false
+	return m
+}
+
+// stop terminates the mailbox processing goroutine and rejects all pending events. It must be called after
+// the state machine is marked as stopped, but outside the events lock, so the goroutine can finish the current event.
+func (m *mailbox[Event]) stop() {
+	close(m.done)
+	<-m.finished
+	m.drain()
+}
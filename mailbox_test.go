@@ -0,0 +1,213 @@
+package gfsm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mailboxState records processed events, blocks on "block" event until release is closed, and posts "back" event
+// to its own state machine on entering Busy.
+type mailboxState struct {
+	id      ConnSM
+	sm      *StateMachineHandler[ConnSM]
+	mu      *sync.Mutex
+	journal *[]string
+	release chan struct{}
+}
+
+func (s *mailboxState) OnEnter(_ StateMachineContext) {
+	if s.id == Busy {
+		_ = (*s.sm).Post(connEvent("back"))
+	}
+}
+
+func (s *mailboxState) OnExit(_ StateMachineContext) {
+}
+
+func (s *mailboxState) Execute(_ StateMachineContext, eventCtx EventContext) ConnSM {
+	event := eventCtx.(connEvent)
+	s.mu.Lock()
+	*s.journal = append(*s.journal, string(event))
+	s.mu.Unlock()
+
+	switch event {
+	case "block":
+		<-s.release
+	case "work":
+		return Busy
+	case "back":
+		return Idle
+	}
+	return s.id
+}
+
+type mailboxFixture struct {
+	sm      StateMachineHandler[ConnSM]
+	mu      sync.Mutex
+	journal []string
+	release chan struct{}
+}
+
+func newMailboxFixture(capacity int, policy OverflowPolicy) *mailboxFixture {
+	f := &mailboxFixture{release: make(chan struct{})}
+	state := func(id ConnSM) *mailboxState {
+		return &mailboxState{id: id, sm: &f.sm, mu: &f.mu, journal: &f.journal, release: f.release}
+	}
+	f.sm = NewBuilder[ConnSM]().
+		SetMailbox(capacity, policy).
+		SetDefaultState(Idle).
+		RegisterState(Idle, state(Idle), []ConnSM{Busy}).
+		RegisterState(Busy, state(Busy), []ConnSM{Idle}).
+		Build()
+	return f
+}
+
+func (f *mailboxFixture) events() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.journal...)
+}
+
+// blockLoop makes the processing goroutine busy with "block" event until f.release is closed.
+func (f *mailboxFixture) blockLoop(t *testing.T) {
+	assert.NoError(t, f.sm.Post(connEvent("block")))
+	assert.Eventually(t, func() bool {
+		events := f.events()
+		return len(events) > 0 && events[len(events)-1] == "block"
+	}, time.Second, time.Millisecond)
+}
+
+// waitQueue waits until the mailbox has exactly n events.
+func (f *mailboxFixture) waitQueue(t *testing.T, n int) {
+	assert.Eventually(t, func() bool {
+		return len(f.sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).eventsMailbox().queue) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestMailboxPostFromCallback(t *testing.T) {
+	f := newMailboxFixture(8, OverflowBlock)
+	f.sm.Start()
+	defer f.sm.Stop()
+
+	assert.NoError(t, f.sm.Send(context.Background(), connEvent("work")))
+	// "back" was posted by Busy.OnEnter and is processed before "noop"
+	assert.NoError(t, f.sm.ProcessEvent(connEvent("noop")))
+	assert.Equal(t, Idle, f.sm.State())
+	assert.Equal(t, []string{"work", "back", "noop"}, f.events())
+}
+
+func TestMailboxOverflowPolicies(t *testing.T) {
+	t.Run("fail", func(t *testing.T) {
+		f := newMailboxFixture(1, OverflowFail)
+		f.sm.Start()
+		defer f.sm.Stop()
+		f.blockLoop(t)
+
+		assert.NoError(t, f.sm.Post(connEvent("first")))
+		assert.ErrorIs(t, f.sm.Post(connEvent("second")), ErrQueueFull)
+		close(f.release)
+		f.waitQueue(t, 0)
+		assert.NoError(t, f.sm.ProcessEvent(connEvent("last")))
+		assert.Equal(t, []string{"block", "first", "last"}, f.events())
+	})
+	t.Run("drop newest", func(t *testing.T) {
+		f := newMailboxFixture(1, OverflowDropNewest)
+		f.sm.Start()
+		defer f.sm.Stop()
+		f.blockLoop(t)
+
+		assert.NoError(t, f.sm.Post(connEvent("first")))
+		assert.NoError(t, f.sm.Post(connEvent("second")))
+		close(f.release)
+		f.waitQueue(t, 0)
+		assert.NoError(t, f.sm.ProcessEvent(connEvent("last")))
+		assert.Equal(t, []string{"block", "first", "last"}, f.events())
+	})
+	t.Run("drop oldest", func(t *testing.T) {
+		f := newMailboxFixture(1, OverflowDropOldest)
+		f.sm.Start()
+		defer f.sm.Stop()
+		f.blockLoop(t)
+
+		dropped := make(chan error)
+		go func() {
+			dropped <- f.sm.Send(context.Background(), connEvent("first"))
+		}()
+		f.waitQueue(t, 1)
+		assert.NoError(t, f.sm.Post(connEvent("second")))
+		assert.ErrorIs(t, <-dropped, ErrQueueFull)
+		close(f.release)
+		f.waitQueue(t, 0)
+		assert.NoError(t, f.sm.ProcessEvent(connEvent("last")))
+		assert.Equal(t, []string{"block", "second", "last"}, f.events())
+	})
+	t.Run("block", func(t *testing.T) {
+		f := newMailboxFixture(1, OverflowBlock)
+		f.sm.Start()
+		defer f.sm.Stop()
+		f.blockLoop(t)
+
+		assert.NoError(t, f.sm.Post(connEvent("first")))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, f.sm.Send(ctx, connEvent("second")), context.DeadlineExceeded)
+		close(f.release)
+		f.waitQueue(t, 0)
+		assert.NoError(t, f.sm.ProcessEvent(connEvent("last")))
+		assert.Equal(t, []string{"block", "first", "last"}, f.events())
+	})
+}
+
+func TestMailboxStopRejectsPendingEvents(t *testing.T) {
+	f := newMailboxFixture(4, OverflowBlock)
+	f.sm.Start()
+	f.blockLoop(t)
+
+	pending := make(chan error)
+	go func() {
+		pending <- f.sm.Send(context.Background(), connEvent("pending"))
+	}()
+	f.waitQueue(t, 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		f.sm.Stop()
+		close(stopped)
+	}()
+	close(f.release)
+	<-stopped
+
+	// the processing goroutine may take "pending" before Stop, but Send must report the actual outcome
+	if err := <-pending; err != nil {
+		assert.ErrorIs(t, err, ErrStopped)
+		assert.Equal(t, []string{"block"}, f.events())
+	} else {
+		assert.Equal(t, []string{"block", "pending"}, f.events())
+	}
+	assert.ErrorIs(t, f.sm.Post(connEvent("late")), ErrStopped)
+}
+
+func TestMailboxStartAfterStop(t *testing.T) {
+	f := newMailboxFixture(4, OverflowBlock)
+	f.sm.Start()
+	f.sm.Stop()
+
+	// posting races with Start, which must not reopen the mailbox
+	posted := make(chan error)
+	go func() {
+		posted <- f.sm.Post(connEvent("late"))
+	}()
+	assert.ErrorIs(t, f.sm.Start(), ErrStopped)
+	assert.ErrorIs(t, <-posted, ErrStopped)
+	assert.Empty(t, f.events())
+}
+
+func TestNoMailbox(t *testing.T) {
+	sm := newSmWithBuilder(t)
+	assert.ErrorIs(t, sm.Post(StartData{}), ErrNoMailbox)
+	assert.ErrorIs(t, sm.Send(context.Background(), StartData{}), ErrNoMailbox)
+}
@@ -0,0 +1,34 @@
+package gfsm
+
+import "context"
+
+// TypedEventHandler processes an event, it is the signature of StateMachineHandler.ProcessEventCtx.
+type TypedEventHandler[Event any] func(ctx context.Context, eventCtx Event) error
+
+// EventHandler is the TypedEventHandler for any EventContext.
+type EventHandler = TypedEventHandler[EventContext]
+
+// TypedMiddleware wraps the event processing the same way as http.Handler middlewares do. The returned handler
+// receives every event passed to ProcessEvent, ProcessEventCtx, Post or Send, and decides whether to call next,
+// which continues with the next middleware and eventually dispatches the event to the states. Not calling next
+// drops the event, and the returned error is reported to the caller.
+type TypedMiddleware[Event any] func(next TypedEventHandler[Event]) TypedEventHandler[Event]
+
+// Middleware is the TypedMiddleware for any EventContext.
+type Middleware = TypedMiddleware[EventContext]
+
+// chainMiddlewares wraps handler into middlewares, the first middleware is the outermost one.
+func chainMiddlewares[Event any](handler TypedEventHandler[Event], middlewares []TypedMiddleware[Event]) TypedEventHandler[Event] {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// handleEvent passes the event through the middlewares chain, if any, to processEventLocked.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) handleEvent(ctx context.Context, eventCtx Event) error {
+	if len(s.middlewares) == 0 {
+		return s.processEventLocked(ctx, eventCtx)
+	}
+	return s.extras.Load().handler(ctx, eventCtx)
+}
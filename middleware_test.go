@@ -0,0 +1,88 @@
+package gfsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errFiltered = errors.New("event filtered")
+
+// tagMiddleware logs the event before and after the next handler call.
+func tagMiddleware(journal *[]string, tag string) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(ctx context.Context, eventCtx EventContext) error {
+			*journal = append(*journal, fmt.Sprintf("%s in %v", tag, eventCtx))
+			err := next(ctx, eventCtx)
+			*journal = append(*journal, fmt.Sprintf("%s out %v", tag, err))
+			return err
+		}
+	}
+}
+
+// filterMiddleware rejects the "spam" event without passing it to the state machine.
+func filterMiddleware(next EventHandler) EventHandler {
+	return func(ctx context.Context, eventCtx EventContext) error {
+		if eventCtx == connEvent("spam") {
+			return errFiltered
+		}
+		return next(ctx, eventCtx)
+	}
+}
+
+func newMiddlewareSM(journal *[]string, configure func(b StateMachineBuilder[ConnSM]) StateMachineBuilder[ConnSM]) StateMachineHandler[ConnSM] {
+	b := NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, &recordingState[ConnSM]{id: Idle, journal: journal, routes: map[connEvent]ConnSM{"work": Busy}}, []ConnSM{Busy}).
+		RegisterState(Busy, &recordingState[ConnSM]{id: Busy, journal: journal}, nil).
+		Use(tagMiddleware(journal, "outer"), tagMiddleware(journal, "inner")).
+		Use(filterMiddleware)
+	return configure(b).Build()
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(b StateMachineBuilder[ConnSM]) StateMachineBuilder[ConnSM]
+	}{
+		{"sync", func(b StateMachineBuilder[ConnSM]) StateMachineBuilder[ConnSM] { return b }},
+		{"mailbox", func(b StateMachineBuilder[ConnSM]) StateMachineBuilder[ConnSM] {
+			return b.SetMailbox(1, OverflowBlock)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var journal []string
+			sm := newMiddlewareSM(&journal, test.configure)
+			sm.Start()
+			defer sm.Stop()
+
+			journal = nil
+			assert.ErrorIs(t, sm.ProcessEvent(connEvent("spam")), errFiltered)
+			assert.Equal(t, []string{
+				"outer in spam",
+				"inner in spam",
+				"inner out event filtered",
+				"outer out event filtered",
+			}, journal)
+			assert.Equal(t, Idle, sm.State())
+
+			journal = nil
+			assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+			assert.Equal(t, []string{
+				"outer in work",
+				"inner in work",
+				"execute 2",
+				"exit 2",
+				"enter 3",
+				"inner out <nil>",
+				"outer out <nil>",
+			}, journal)
+			assert.Equal(t, Busy, sm.State())
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package gfsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// observingState records its callbacks together with the state reported by the state machine at the moment.
+type observingState struct {
+	recordingState[ConnSM]
+	sm *StateMachineHandler[ConnSM]
+}
+
+func (s *observingState) OnEnter(_ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("enter %v in %v", s.id, (*s.sm).State()))
+}
+
+func (s *observingState) OnExit(_ StateMachineContext) {
+	*s.journal = append(*s.journal, fmt.Sprintf("exit %v in %v", s.id, (*s.sm).State()))
+}
+
+func newObservingBuilder(journal *[]string, sm *StateMachineHandler[ConnSM]) StateMachineBuilder[ConnSM] {
+	state := func(id ConnSM, routes map[connEvent]ConnSM) *observingState {
+		return &observingState{recordingState: recordingState[ConnSM]{id: id, journal: journal, routes: routes}, sm: sm}
+	}
+	return NewBuilder[ConnSM]().
+		SetThreadSafe(true).
+		SetDefaultState(Disconnected).
+		RegisterState(Disconnected, state(Disconnected, map[connEvent]ConnSM{"connect": Connected}), []ConnSM{Connected}).
+		RegisterState(Connected, state(Connected, map[connEvent]ConnSM{"drop": Disconnected}), []ConnSM{Disconnected}).
+		RegisterSubState(Connected, Idle, state(Idle, map[connEvent]ConnSM{"work": Busy}), []ConnSM{Busy}).
+		RegisterSubState(Connected, Busy, state(Busy, nil), nil).
+		SetDefaultSubState(Connected, Idle)
+}
+
+func TestTransitionOrdering(t *testing.T) {
+	var journal []string
+	var sm StateMachineHandler[ConnSM]
+	observe := func(name string) TransitionAction {
+		return func(_ StateMachineContext, _ EventContext) {
+			journal = append(journal, fmt.Sprintf("%v in %v", name, sm.State()))
+		}
+	}
+	sm = newObservingBuilder(&journal, &sm).
+		SetTransitionAction(Disconnected, Connected, observe("connect")).
+		AddTransition(Busy, connEvent("drop"), nil, Disconnected, observe("drop")).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+
+	// the transition returned by Execute uses the action set with SetTransitionAction
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, []string{
+		"execute 0",
+		"exit 0 in 0",
+		"connect in 0",
+		"enter 1 in 1",
+		"enter 2 in 1",
+	}, journal)
+	assert.Equal(t, Idle, sm.State())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("drop")))
+	assert.Equal(t, []string{
+		"exit 3 in 3",
+		"exit 1 in 3",
+		"drop in 3",
+		"enter 0 in 0",
+	}, journal)
+	assert.Equal(t, Disconnected, sm.State())
+}
+
+func TestTransitionActionValidation(t *testing.T) {
+	var journal []string
+	var sm StateMachineHandler[ConnSM]
+	noop := func(_ StateMachineContext, _ EventContext) {}
+
+	_, err := newObservingBuilder(&journal, &sm).
+		SetTransitionAction(100, Idle, noop).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+
+	// the action target is an allowed transition
+	sm = newObservingBuilder(&journal, &sm).
+		SetTransitionAction(Busy, Idle, noop).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.NoError(t, sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).ChangeState(Idle))
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestTransitionActionDefinition(t *testing.T) {
+	var journal []string
+	record := func(tag string) TransitionAction {
+		return func(_ StateMachineContext, _ EventContext) {
+			journal = append(journal, tag)
+		}
+	}
+	builder := newConnBuilder(&journal).
+		SetTransitionAction(Disconnected, Connected, record("built"))
+	def, err := builder.BuildDefinition()
+	assert.NoError(t, err)
+
+	// the definition is not affected by the builder anymore
+	builder.SetTransitionAction(Disconnected, Connected, record("later"))
+	sm := def.NewInstance(nil)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, []string{"execute 0", "exit 0", "built", "enter 1", "enter 2"}, journal)
+}
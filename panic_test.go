@@ -0,0 +1,152 @@
+package gfsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const Broken ConnSM = 10
+
+var errDriver = fmt.Errorf("driver failure")
+
+// panickingState is the recordingState panicking in OnEnter while armed is set.
+type panickingState struct {
+	recordingState[ConnSM]
+	armed bool
+}
+
+func (s *panickingState) OnEnter(smCtx StateMachineContext) {
+	s.recordingState.OnEnter(smCtx)
+	if s.armed {
+		panic(errDriver)
+	}
+}
+
+func newPanicBuilder(journal *[]string, busy *panickingState) StateMachineBuilder[ConnSM] {
+	state := func(id ConnSM, routes map[connEvent]ConnSM) *recordingState[ConnSM] {
+		return &recordingState[ConnSM]{id: id, journal: journal, routes: routes}
+	}
+	busy.recordingState = recordingState[ConnSM]{id: Busy, journal: journal, routes: map[connEvent]ConnSM{"done": Idle}}
+	return NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		SetPanicRecovery(true).
+		RegisterState(Disconnected, state(Disconnected, map[connEvent]ConnSM{"connect": Connected}), []ConnSM{Connected}).
+		RegisterState(Connected, state(Connected, map[connEvent]ConnSM{"drop": Disconnected}), []ConnSM{Disconnected}).
+		RegisterSubState(Connected, Idle, state(Idle, map[connEvent]ConnSM{"work": Busy}), []ConnSM{Busy}).
+		RegisterSubState(Connected, Busy, busy, []ConnSM{Idle}).
+		SetDefaultSubState(Connected, Idle)
+}
+
+func TestPanicRollback(t *testing.T) {
+	var journal []string
+	busy := &panickingState{armed: true}
+	sm, err := newPanicBuilder(&journal, busy).BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+
+	journal = nil
+	err = sm.ProcessEvent(connEvent("work"))
+	var panicErr *PanicError[ConnSM]
+	if assert.ErrorAs(t, err, &panicErr) {
+		assert.Equal(t, Busy, panicErr.State)
+		assert.Equal(t, PhaseOnEnter, panicErr.Phase)
+		assert.Contains(t, string(panicErr.Stack), "panickingState")
+	}
+	assert.ErrorIs(t, err, errDriver)
+	assert.Equal(t, "state 3 panicked in OnEnter: driver failure", err.Error())
+	// Busy is entered before the panic, and Idle is entered again
+	assert.Equal(t, []string{"execute 2", "exit 2", "enter 3", "enter 2"}, journal)
+	assert.Equal(t, Idle, sm.State())
+
+	busy.armed = false
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, Busy, sm.State())
+}
+
+func TestPanicRollbackReenter(t *testing.T) {
+	var journal []string
+	crash := func(_ StateMachineContext, _ EventContext) {
+		panic("bad action")
+	}
+	sm := newPanicBuilder(&journal, &panickingState{}).
+		SetTransitionAction(Connected, Disconnected, crash).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	journal = nil
+	var panicErr *PanicError[ConnSM]
+	assert.ErrorAs(t, sm.ProcessEvent(connEvent("drop")), &panicErr)
+	assert.Equal(t, PhaseTransitionAction, panicErr.Phase)
+	// the exited states are entered again from the outermost one
+	assert.Equal(t, []string{"execute 3", "execute 1", "exit 3", "exit 1", "enter 1", "enter 3"}, journal)
+	assert.Equal(t, Busy, sm.State())
+}
+
+func TestPanicErrorState(t *testing.T) {
+	var journal []string
+	busy := &panickingState{armed: true}
+	sm, err := newPanicBuilder(&journal, busy).
+		RegisterState(Broken, &recordingState[ConnSM]{id: Broken, journal: &journal, routes: map[connEvent]ConnSM{"repair": Disconnected}}, []ConnSM{Disconnected}).
+		SetErrorState(Broken).
+		BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+
+	journal = nil
+	assert.ErrorIs(t, sm.ProcessEvent(connEvent("work")), errDriver)
+	// the failed states are not exited
+	assert.Equal(t, []string{"execute 2", "exit 2", "enter 3", "enter 10"}, journal)
+	assert.Equal(t, Broken, sm.State())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("repair")))
+	assert.Equal(t, Disconnected, sm.State())
+}
+
+func TestPanicErrorStateValidation(t *testing.T) {
+	var journal []string
+	_, err := newPanicBuilder(&journal, &panickingState{}).
+		SetErrorState(Broken).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+}
+
+func TestPanicGuard(t *testing.T) {
+	var journal []string
+	guard := func(_ StateMachineContext, _ EventContext) bool {
+		panic("bad guard")
+	}
+	sm := newPanicBuilder(&journal, &panickingState{}).
+		AddTransition(Idle, connEvent("check"), guard, Busy, nil).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+
+	var panicErr *PanicError[ConnSM]
+	assert.ErrorAs(t, sm.ProcessEvent(connEvent("check")), &panicErr)
+	assert.Equal(t, Idle, panicErr.State)
+	assert.Equal(t, PhaseGuard, panicErr.Phase)
+	assert.Equal(t, "bad guard", panicErr.Value)
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestPanicRecoveryDisabled(t *testing.T) {
+	var journal []string
+	sm := newPanicBuilder(&journal, &panickingState{armed: true}).
+		SetPanicRecovery(false).
+		Build()
+	assert.NoError(t, sm.Start())
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Panics(t, func() {
+		_ = sm.ProcessEvent(connEvent("work"))
+	})
+}
@@ -0,0 +1,97 @@
+package gfsm
+
+import "fmt"
+
+var (
+	// ErrNoStore is returned by StateMachineHandler.Persist of state machines without a store or an instance ID.
+	ErrNoStore = fmt.Errorf("state machine has no store")
+	// ErrSnapshotNotFound is returned by Store.Load if there is no snapshot for the instance ID.
+	ErrSnapshotNotFound = fmt.Errorf("snapshot not found")
+)
+
+// Store persists state machine snapshots keyed by instance ID. The store package provides in-memory and file
+// implementations. Stores must be safe for concurrent use, as many state machine instances can share one store.
+type Store[StateIdentifier comparable] interface {
+	// Save replaces the snapshot of the instance id.
+	Save(id string, snapshot Snapshot[StateIdentifier]) error
+	// Load returns the last saved snapshot of the instance id, or ErrSnapshotNotFound.
+	Load(id string) (Snapshot[StateIdentifier], error)
+	// Delete removes the snapshot of the instance id, deleting a missing snapshot is not an error.
+	Delete(id string) error
+}
+
+// PersistPolicy defines when a state machine saves its snapshot into the store.
+type PersistPolicy int
+
+const (
+	// PersistWriteThrough saves the snapshot after Start, Reset and each event or ChangeState call which changed
+	// the active states. A failure to save is returned from these calls, while the transition is already done.
+	PersistWriteThrough PersistPolicy = iota
+	// PersistOnDemand saves the snapshot only on StateMachineHandler.Persist calls.
+	PersistOnDemand
+)
+
+// NewPersistentInstance creates new state machine the same way as NewInstance does, and makes it save its
+// snapshots into the definition store with the id key.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) NewPersistentInstance(id string, smCtx SmContext) TypedStateMachineHandler[StateIdentifier, Event, SmContext] {
+	s := d.def.newInstance(smCtx)
+	s.setID(id)
+	return s
+}
+
+// Load restores the state machine instance id from the definition store, see Restore for details. The restored
+// instance keeps saving its snapshots with the same id.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) Load(
+	id string,
+	smCtx SmContext,
+	mode RestoreMode) (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+
+	if d.def.store == nil {
+		return nil, ErrNoStore
+	}
+	snapshot, err := d.def.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	s, err := d.def.restore(snapshot, smCtx, mode)
+	if err != nil {
+		return nil, err
+	}
+	s.setID(id)
+	return s, nil
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Persist() error {
+	s.lockEvents()
+	defer s.unlockEvents()
+	return s.persist()
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) persist() error {
+	id := s.instanceID()
+	if s.store == nil || id == "" {
+		return ErrNoStore
+	}
+	snapshot, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	if err := s.store.Save(id, snapshot); err != nil {
+		return fmt.Errorf("cannot save state machine %q: %w", id, err)
+	}
+	return nil
+}
+
+// writeThrough saves the snapshot if the state machine uses PersistWriteThrough policy and the active states were
+// changed since the last call. The result of the event processing err is returned if it is not nil.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) writeThrough(err error) error {
+	x := s.extras.Load()
+	if x == nil || !x.changed {
+		return err
+	}
+	x.changed = false
+	if persistErr := s.persist(); err == nil {
+		return persistErr
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package gfsm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapStore is the Store for tests, the store package cannot be used here because of the import cycle.
+type mapStore[StateIdentifier comparable] struct {
+	snapshots map[string]Snapshot[StateIdentifier]
+	saves     int
+	err       error
+}
+
+func newMapStore[StateIdentifier comparable]() *mapStore[StateIdentifier] {
+	return &mapStore[StateIdentifier]{snapshots: map[string]Snapshot[StateIdentifier]{}}
+}
+
+func (m *mapStore[StateIdentifier]) Save(id string, snapshot Snapshot[StateIdentifier]) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.saves++
+	m.snapshots[id] = snapshot
+	return nil
+}
+
+func (m *mapStore[StateIdentifier]) Load(id string) (Snapshot[StateIdentifier], error) {
+	snapshot, ok := m.snapshots[id]
+	if !ok {
+		return Snapshot[StateIdentifier]{}, ErrSnapshotNotFound
+	}
+	return snapshot, nil
+}
+
+func (m *mapStore[StateIdentifier]) Delete(id string) error {
+	delete(m.snapshots, id)
+	return nil
+}
+
+func TestPersistWriteThrough(t *testing.T) {
+	var journal []string
+	store := newMapStore[ConnSM]()
+	sm := newConnBuilder(&journal).
+		SetStore(store, PersistWriteThrough).
+		SetInstanceID("conn-1").
+		Build()
+
+	sm.Start()
+	assert.Equal(t, Snapshot[ConnSM]{State: Disconnected}, store.snapshots["conn-1"])
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, Snapshot[ConnSM]{State: Idle}, store.snapshots["conn-1"])
+	assert.Equal(t, 2, store.saves)
+
+	// ignored events do not change the state and are not persisted
+	assert.NoError(t, sm.ProcessEvent(connEvent("unknown")))
+	assert.Equal(t, 2, store.saves)
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	sm.Stop()
+
+	journal = nil
+	def, err := newConnBuilder(&journal).
+		SetStore(store, PersistWriteThrough).
+		BuildDefinition()
+	assert.NoError(t, err)
+	restored, err := def.Load("conn-1", nil, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.Equal(t, Busy, restored.State())
+	assert.Empty(t, journal)
+
+	// the loaded instance keeps persisting with the same ID
+	assert.NoError(t, restored.ProcessEvent(connEvent("done")))
+	assert.Equal(t, Snapshot[ConnSM]{State: Idle}, store.snapshots["conn-1"])
+
+	_, err = def.Load("conn-2", nil, RestoreSkipOnEnter)
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+}
+
+func TestPersistOnDemand(t *testing.T) {
+	store := newMapStore[StartStopSM]()
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		SetContextCodec(counterCodec{limit: 3}).
+		SetStore(store, PersistOnDemand).
+		BuildDefinition()
+	assert.NoError(t, err)
+
+	sm := def.NewPersistentInstance("counter", &counterContext{limit: 3})
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 2}))
+	assert.Equal(t, 0, store.saves)
+
+	assert.NoError(t, sm.Persist())
+	assert.Equal(t, Snapshot[StartStopSM]{State: InProgress, Context: []byte("2")}, store.snapshots["counter"])
+
+	restored, err := def.Load("counter", nil, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.ProcessEvent(counterEvent{delta: 1}))
+	assert.Equal(t, Stop, restored.State())
+}
+
+func TestPersistErrors(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+	sm.Start()
+	assert.ErrorIs(t, sm.Persist(), ErrNoStore)
+
+	store := newMapStore[ConnSM]()
+	sm = newConnBuilder(&journal).
+		SetStore(store, PersistWriteThrough).
+		SetInstanceID("conn-1").
+		Build()
+	sm.Start()
+
+	// the transition is done even if the snapshot cannot be saved
+	store.err = fmt.Errorf("disk full")
+	err := sm.ProcessEvent(connEvent("connect"))
+	assert.ErrorIs(t, err, store.err)
+	assert.Equal(t, Idle, sm.State())
+
+	store.err = nil
+	assert.NoError(t, sm.Persist())
+	assert.Equal(t, Snapshot[ConnSM]{State: Idle}, store.snapshots["conn-1"])
+}
@@ -0,0 +1,160 @@
+package gfsm
+
+import "fmt"
+
+// PseudoStateKind is the kind of a pseudo-state: a transition target which is not a state, but defines which states
+// the transition enters.
+type PseudoStateKind int
+
+const (
+	// ShallowHistory pseudo-state enters the sub-state of its parent which was active when the parent was exited
+	// last time. The restored sub-state enters its default sub-states, if any.
+	ShallowHistory PseudoStateKind = iota
+	// DeepHistory pseudo-state restores the whole configuration below its parent which was active when the parent
+	// was exited last time.
+	DeepHistory
+	// Choice pseudo-state forwards the transition to the target of its first branch with passing guard. Guards are
+	// evaluated after the source states are exited and the transition action is executed, so they see the context
+	// updated by the action.
+	Choice
+	// Junction pseudo-state forwards the transition the same way Choice does, but guards are evaluated before any
+	// state is exited, and the transition is rejected if none of them passes.
+	Junction
+)
+
+func (k PseudoStateKind) String() string {
+	switch k {
+	case ShallowHistory:
+		return "ShallowHistory"
+	case DeepHistory:
+		return "DeepHistory"
+	case Choice:
+		return "Choice"
+	case Junction:
+		return "Junction"
+	default:
+		return fmt.Sprintf("PseudoStateKind(%d)", int(k))
+	}
+}
+
+// pseudoState is a pseudo-state registered with StateMachineBuilder.RegisterHistoryState, RegisterChoiceState or
+// RegisterJunctionState.
+type pseudoState[StateIdentifier comparable, Event any, SmContext any] struct {
+	kind PseudoStateKind
+	// parent is the composite state a history pseudo-state belongs to.
+	parent StateIdentifier
+	// branches of a choice or junction pseudo-state in declaration order.
+	branches []branch[StateIdentifier, Event, SmContext]
+}
+
+// branch is an outgoing transition of a choice or junction pseudo-state added with StateMachineBuilder.AddBranch.
+type branch[StateIdentifier comparable, Event any, SmContext any] struct {
+	guard    TypedGuard[Event, SmContext]
+	targetID StateIdentifier
+}
+
+// selectBranch returns the target of the first branch with passing guard.
+func (p *pseudoState[StateIdentifier, Event, SmContext]) selectBranch(smCtx SmContext, eventCtx Event) (StateIdentifier, bool) {
+	for _, b := range p.branches {
+		if b.guard == nil || b.guard(smCtx, eventCtx) {
+			return b.targetID, true
+		}
+	}
+	var none StateIdentifier
+	return none, false
+}
+
+// isBranching reports whether the pseudo-state is a choice or a junction.
+func (p *pseudoState[StateIdentifier, Event, SmContext]) isBranching() bool {
+	return p.kind == Choice || p.kind == Junction
+}
+
+// selectJunctions follows junction pseudo-states starting from targetID until it reaches a state or another kind of
+// pseudo-state. It returns false if none of the branches of a junction can be taken.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) selectJunctions(targetID StateIdentifier, eventCtx Event) (StateIdentifier, bool) {
+	for {
+		pseudo, ok := s.pseudo[targetID]
+		if !ok || pseudo.kind != Junction {
+			return targetID, true
+		}
+		s.enterSite(targetID, PhaseGuard)
+		nextID, ok := pseudo.selectBranch(s.smCtx, eventCtx)
+		s.leaveSite()
+		if !ok {
+			return targetID, false
+		}
+		targetID = nextID
+	}
+}
+
+// selectChoice returns the target of the taken branch if targetID is a choice pseudo-state, or targetID otherwise.
+// Each choice has a branch without guard, so one of them is always taken.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) selectChoice(targetID StateIdentifier, eventCtx Event) StateIdentifier {
+	pseudo, ok := s.pseudo[targetID]
+	if !ok || pseudo.kind != Choice {
+		return targetID
+	}
+	s.enterSite(targetID, PhaseGuard)
+	defer s.leaveSite()
+	nextID, _ := pseudo.selectBranch(s.smCtx, eventCtx)
+	return nextID
+}
+
+// branchTargets appends the targets of the choice or junction pseudoID to targets, following the branches leading to
+// other choices and junctions.
+func (d *definition[StateIdentifier, Event, SmContext]) branchTargets(pseudoID StateIdentifier, targets []StateIdentifier) []StateIdentifier {
+	for _, b := range d.pseudo[pseudoID].branches {
+		if pseudo, ok := d.pseudo[b.targetID]; ok && pseudo.isBranching() {
+			targets = d.branchTargets(b.targetID, targets)
+			continue
+		}
+		targets = append(targets, b.targetID)
+	}
+	return targets
+}
+
+// checkBranches verifies that sourceID allows the state a transition to nextStateID ends in: targetID selected by
+// the junctions, or, as choice guards are evaluated after the source states are exited, every branch target of
+// a choice targetID.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) checkBranches(sourceID, nextStateID, targetID StateIdentifier) error {
+	targets := []StateIdentifier{targetID}
+	if pseudo, ok := s.pseudo[targetID]; ok && pseudo.kind == Choice {
+		targets = s.branchTargets(targetID, nil)
+	}
+	for _, branchID := range targets {
+		if branchID != nextStateID && !s.canSwitch(sourceID, branchID) {
+			return fmt.Errorf("cannot switch from %v to %v through %v: %w", sourceID, branchID, nextStateID,
+				ErrInvalidTransition)
+		}
+	}
+	return nil
+}
+
+// resolveHistory returns the state entered by a transition to targetID, which is either a state or a history
+// pseudo-state.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) resolveHistory(targetID StateIdentifier) (StateIdentifier, historyEntry[StateIdentifier]) {
+	pseudo, ok := s.pseudo[targetID]
+	if !ok || pseudo.isBranching() {
+		return targetID, historyEntry[StateIdentifier]{}
+	}
+	return pseudo.parent, historyEntry[StateIdentifier]{parentID: pseudo.parent, deep: pseudo.kind == DeepHistory, active: true}
+}
+
+// encloses reports whether ancestorID is a proper ancestor of targetID, or, for pseudo-states, of every state the
+// pseudo-state can enter.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) encloses(ancestorID, targetID StateIdentifier) bool {
+	pseudo, ok := s.pseudo[targetID]
+	switch {
+	case !ok:
+		return s.isAncestor(ancestorID, targetID)
+	case pseudo.isBranching():
+		for _, b := range pseudo.branches {
+			if !s.encloses(ancestorID, b.targetID) {
+				return false
+			}
+		}
+		return true
+	default:
+		return s.isAncestor(ancestorID, pseudo.parent)
+	}
+}
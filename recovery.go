@@ -0,0 +1,187 @@
+package gfsm
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// CallbackPhase is the kind of callback reported by PanicError.
+type CallbackPhase int
+
+const (
+	// PhaseUnknown is reported for panics raised outside state callbacks, guards, actions and listeners.
+	PhaseUnknown CallbackPhase = iota
+	PhaseOnEnter
+	PhaseOnExit
+	PhaseExecute
+	PhaseGuard
+	PhaseTransitionAction
+	PhaseListener
+)
+
+func (p CallbackPhase) String() string {
+	switch p {
+	case PhaseOnEnter:
+		return "OnEnter"
+	case PhaseOnExit:
+		return "OnExit"
+	case PhaseExecute:
+		return "Execute"
+	case PhaseGuard:
+		return "guard"
+	case PhaseTransitionAction:
+		return "transition action"
+	case PhaseListener:
+		return "listener"
+	default:
+		return "unknown phase"
+	}
+}
+
+// PanicError is returned instead of a panic raised by a callback of a state machine with panic recovery enabled,
+// see StateMachineBuilder.SetPanicRecovery.
+type PanicError[StateIdentifier comparable] struct {
+	// State is the state which callback panicked. For transition actions it is the transition source, and for
+	// listeners it is the value State() returned before the notified activity.
+	State StateIdentifier
+	Phase CallbackPhase
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError[StateIdentifier]) Error() string {
+	return fmt.Sprintf("state %v panicked in %v: %v", e.State, e.Phase, e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError[StateIdentifier]) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// callbackSite is the callback being executed, it is reported in PanicError.
+type callbackSite[StateIdentifier comparable] struct {
+	stateID StateIdentifier
+	phase   CallbackPhase
+}
+
+// enterSite records the callback about to be called, leaveSite is called after it returns. The callback is recorded
+// only if panic recovery is enabled.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enterSite(stateID StateIdentifier, phase CallbackPhase) {
+	if s.recoverPanics {
+		s.extra().site = callbackSite[StateIdentifier]{stateID: stateID, phase: phase}
+	}
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) leaveSite() {
+	if s.recoverPanics {
+		s.extra().site.phase = PhaseUnknown
+	}
+}
+
+// protect runs fn, which changes the active configuration, under the events lock. If panic recovery is enabled,
+// a panic raised by fn is returned as *PanicError, and the state machine is moved to the error state, if it is set,
+// or the configuration active before fn is restored otherwise. The restored states exited by fn are entered again
+// with OnEnter, while the states entered by fn before the panic are not exited.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) protect(ctx context.Context, fn func() error) error {
+	if !s.recoverPanics {
+		return fn()
+	}
+	return s.recoverTo(ctx, s.hasErrorState, fn)
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) recoverTo(ctx context.Context, toErrorState bool, fn func() error) (err error) {
+	leafID, leaves := s.currentStateID, s.activeLeaves
+	x := s.extra()
+	x.exited = nil
+	defer func() {
+		exited := x.exited
+		x.exited = nil
+		r := recover()
+		if r == nil {
+			return
+		}
+		panicErr := &PanicError[StateIdentifier]{State: x.site.stateID, Phase: x.site.phase, Value: r, Stack: debug.Stack()}
+		s.leaveSite()
+		x.entering = historyEntry[StateIdentifier]{}
+		s.stopTimers()
+		s.markChanged()
+		if !toErrorState {
+			s.lockState()
+			s.currentStateID, s.activeLeaves = leafID, leaves
+			s.unlockState()
+			if s.status == StatusRunning {
+				// the exited states have released their resources, a panic here leaves them as they are
+				_ = s.recoverTo(ctx, false, func() error {
+					s.enterExited(ctx, exited)
+					return nil
+				})
+			}
+			// the exited states have lost their timeouts
+			s.reenter(ctx, false)
+			err = panicErr
+			return
+		}
+		s.enterErrorState(ctx)
+		err = panicErr
+	}()
+	return fn()
+}
+
+// enterExited calls OnEnter of the active states from exited in reverse exit order, so each state is entered before
+// its sub-states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enterExited(ctx context.Context, exited []StateIdentifier) {
+	entered := make(map[StateIdentifier]struct{}, len(exited))
+	for i := len(exited) - 1; i >= 0; i-- {
+		stateID := exited[i]
+		if _, ok := entered[stateID]; ok || !s.isActive(stateID) {
+			// exited more than once, or entered and exited again by fn
+			continue
+		}
+		entered[stateID] = struct{}{}
+		s.enterSite(stateID, PhaseOnEnter)
+		st := s.states[stateID]
+		st.onEnter(ctx, s.smCtx)
+		s.leaveSite()
+	}
+}
+
+// enterErrorState makes the error state active without calling OnExit of the active states, which can be broken,
+// and then calls OnEnter of the error state. A panic in the error state OnEnter leaves the error state active.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) enterErrorState(ctx context.Context) {
+	leaves := leafSet[StateIdentifier]{}
+	s.defaultLeaves(s.errorStateID, &leaves)
+	s.setConfiguration(leaves)
+	_ = s.recoverTo(ctx, false, func() error {
+		entered := leafSet[StateIdentifier]{}
+		s.enter(ctx, s.errorStateID, false, s.errorStateID, &entered)
+		return nil
+	})
+}
+
+// defaultLeaves adds to leaves the leaf states entered together with stateID, without calling any callbacks.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) defaultLeaves(stateID StateIdentifier, leaves *leafSet[StateIdentifier]) {
+	st := s.states[stateID]
+	switch {
+	case st.parallel:
+		for _, regionID := range st.regions {
+			s.defaultLeaves(regionID, leaves)
+		}
+	case st.composite:
+		s.defaultLeaves(st.defaultSubState, leaves)
+	default:
+		leaves.add(stateID)
+	}
+}
+
+// stopTimers cancels timeouts of all the states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) stopTimers() {
+	if x := s.extras.Load(); x != nil {
+		for stateID := range x.timers {
+			s.stopTimer(stateID)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type DeviceSM string
+
+const (
+	Off          DeviceSM = "Off"
+	On           DeviceSM = "On"
+	Power        DeviceSM = "Power"
+	Battery      DeviceSM = "Battery"
+	Mains        DeviceSM = "Mains"
+	Connectivity DeviceSM = "Connectivity"
+	Offline      DeviceSM = "Offline"
+	Online       DeviceSM = "Online"
+)
+
+func newDeviceSM(journal *[]string) StateMachineHandler[DeviceSM] {
+	return newDeviceBuilder(journal).Build()
+}
+
+func newDeviceBuilder(journal *[]string) StateMachineBuilder[DeviceSM] {
+	state := func(id DeviceSM, routes map[connEvent]DeviceSM) *recordingState[DeviceSM] {
+		return &recordingState[DeviceSM]{id: id, journal: journal, routes: routes}
+	}
+	return NewBuilder[DeviceSM]().
+		SetDefaultState(Off).
+		RegisterState(Off, state(Off, map[connEvent]DeviceSM{"power": On}), []DeviceSM{On}).
+		RegisterState(On, state(On, map[connEvent]DeviceSM{"power": Off}), []DeviceSM{Off}).
+		SetParallelState(On).
+		RegisterSubState(On, Power, state(Power, nil), nil).
+		RegisterSubState(Power, Battery, state(Battery, map[connEvent]DeviceSM{"plug": Mains}), []DeviceSM{Mains}).
+		RegisterSubState(Power, Mains, state(Mains, map[connEvent]DeviceSM{"unplug": Battery}), []DeviceSM{Battery}).
+		SetDefaultSubState(Power, Battery).
+		RegisterSubState(On, Connectivity, state(Connectivity, nil), nil).
+		RegisterSubState(Connectivity, Offline, state(Offline, map[connEvent]DeviceSM{"plug": Online}), []DeviceSM{Online}).
+		RegisterSubState(Connectivity, Online, state(Online, map[connEvent]DeviceSM{"drop": Off}), []DeviceSM{Off}).
+		SetDefaultSubState(Connectivity, Offline)
+}
+
+func TestParallelRegionsEnterExit(t *testing.T) {
+	var journal []string
+	sm := newDeviceSM(&journal)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("power")))
+	assert.Equal(t, On, sm.State())
+	assert.Equal(t, []DeviceSM{Battery, Offline}, sm.ActiveStates())
+	assert.Equal(t, []string{
+		"enter Off", "execute Off", "exit Off",
+		"enter On", "enter Power", "enter Battery", "enter Connectivity", "enter Offline",
+	}, journal)
+
+	// handled by the parallel state itself, all regions are exited in reverse order
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("power")))
+	assert.Equal(t, Off, sm.State())
+	assert.Equal(t, []DeviceSM{Off}, sm.ActiveStates())
+	assert.Equal(t, []string{
+		"execute Battery", "execute Power", "execute Offline", "execute Connectivity", "execute On",
+		"exit Offline", "exit Connectivity", "exit Battery", "exit Power", "exit On", "enter Off",
+	}, journal)
+}
+
+func TestParallelRegionsDispatch(t *testing.T) {
+	var journal []string
+	sm := newDeviceSM(&journal)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("power")))
+
+	// both regions react on the same event independently, the parallel state is not consulted
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("plug")))
+	assert.Equal(t, On, sm.State())
+	assert.Equal(t, []DeviceSM{Mains, Online}, sm.ActiveStates())
+	assert.Equal(t, []string{
+		"execute Battery", "exit Battery", "enter Mains",
+		"execute Offline", "exit Offline", "enter Online",
+	}, journal)
+
+	// a transition out of the parallel state from one region exits all of them
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("drop")))
+	assert.Equal(t, Off, sm.State())
+	assert.Equal(t, []string{
+		"execute Mains", "execute Power",
+		"execute Online", "exit Online", "exit Connectivity", "exit Mains", "exit Power", "exit On", "enter Off",
+	}, journal)
+}
+
+func TestParallelRegionsStop(t *testing.T) {
+	var journal []string
+	sm := newDeviceSM(&journal)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("power")))
+
+	journal = nil
+	sm.Stop()
+	assert.Equal(t, []string{"exit Offline", "exit Connectivity", "exit Battery", "exit Power", "exit On"}, journal)
+}
@@ -0,0 +1,75 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pingEvent struct{}
+
+func TestExternalSelfTransition(t *testing.T) {
+	var journal []string
+	sm, err := newConnBuilder(&journal).
+		SetSelfTransition(Busy, ExternalTransition).
+		BuildE()
+	assert.NoError(t, err)
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+
+	// Idle keeps the default internal self-transition, and the event bubbles to Connected
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("again")))
+	assert.Equal(t, []string{"execute 2", "execute 1"}, journal)
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("again")))
+	assert.Equal(t, Busy, sm.State())
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 3"}, journal)
+
+	desc := sm.Describe()
+	for _, st := range desc.States {
+		if st.ID == Busy {
+			assert.Equal(t, ExternalTransition, st.SelfTransition)
+			assert.Contains(t, st.Transitions, Busy)
+		}
+	}
+}
+
+func TestInternalTransition(t *testing.T) {
+	var journal []string
+	pings := 0
+	countPing := func(_ StateMachineContext, _ EventContext) {
+		pings++
+	}
+	sm := newConnBuilder(&journal).
+		AddInternalTransition(Idle, pingEvent{}, nil, countPing).
+		AddTransition(Busy, pingEvent{}, nil, Busy, countPing).
+		Build()
+	assert.NoError(t, sm.Start())
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(pingEvent{}))
+	assert.Equal(t, Idle, sm.State())
+	assert.Empty(t, journal)
+
+	// a declarative transition to the source itself is an external one
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(pingEvent{}))
+	assert.Equal(t, Busy, sm.State())
+	assert.Equal(t, []string{"exit 3", "enter 3"}, journal)
+	assert.Equal(t, 2, pings)
+}
+
+func TestSelfTransitionValidation(t *testing.T) {
+	var journal []string
+	_, err := newConnBuilder(&journal).
+		SetSelfTransition(100, ExternalTransition).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+}
@@ -1,68 +1,786 @@
 package gfsm
 
-import "fmt"
+import (
+	"reflect"
+	"time"
+)
 
-// StateMachineBuilder interface provides access to a builder that simplifies state machine creation. Builder usage is optional,
+// TypedStateMachineBuilder interface provides access to a builder that simplifies state machine creation. Builder usage is optional,
 // and state machine object can be created manually if needed.
 // Refer to newSmManual test for manual state machine creation or newSmWithBuilder as the alternative approach with builder.
-type StateMachineBuilder[StateIdentifier comparable] interface {
+type TypedStateMachineBuilder[StateIdentifier comparable, Event any, SmContext any] interface {
 	// SetSMName provides optional name for the SM. Primary uses for debugging proposes in cases when an app has more than one state machine.
-	SetSMName(smName string) StateMachineBuilder[StateIdentifier]
+	SetSMName(smName string) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
 	// RegisterState call register one more state referenced by stateID with list of all valid transactions listed in transitions
 	// and handler (action) into the state machine.
-	RegisterState(stateID StateIdentifier, action StateAction[StateIdentifier], transitions []StateIdentifier) StateMachineBuilder[StateIdentifier]
+	RegisterState(stateID StateIdentifier, action TypedStateAction[StateIdentifier, Event, SmContext], transitions []StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// RegisterSubState call registers stateID the same way as RegisterState does, but as a child of the composite
+	// state parentID. Events not handled by the sub-state bubble to the parent, and transitions listed for the parent
+	// are valid from any of its sub-states.
+	RegisterSubState(parentID StateIdentifier, stateID StateIdentifier, action TypedStateAction[StateIdentifier, Event, SmContext], transitions []StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetDefaultSubState tells which sub-state is entered together with the composite state parentID. Each composite
+	// state must have a default sub-state.
+	SetDefaultSubState(parentID StateIdentifier, stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetParallelState turns the composite state stateID into a state with orthogonal regions. Each sub-state of the
+	// parallel state is a region, and all of them are active together with the parallel state. Regions are entered
+	// in registration order and exited in reverse order. Parallel state does not need a default sub-state.
+	SetParallelState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// RegisterHistoryState adds the history pseudo-state historyID of kind ShallowHistory or DeepHistory to the
+	// composite state parentID. historyID is used as a transition target the same way as states are, and the
+	// transition to it enters parentID with the sub-states active when parentID was exited last time, or with the
+	// default ones if parentID has not been exited yet. Reset clears the saved sub-states.
+	RegisterHistoryState(parentID StateIdentifier, historyID StateIdentifier, kind PseudoStateKind) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// RegisterChoiceState adds the choice pseudo-state choiceID, which is used as a transition target the same way as
+	// states are, and forwards the transition to one of its branches added with AddBranch, see Choice. A choice must
+	// have a branch without guard, and its branches cannot lead to other choices or junctions.
+	RegisterChoiceState(choiceID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// RegisterJunctionState adds the junction pseudo-state junctionID, which is the same as a choice, but selects the
+	// branch before the source states are exited, see Junction. Junctions can be chained with other junctions and
+	// choices.
+	RegisterJunctionState(junctionID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// AddBranch adds the branch to targetID taken if guard returns true (nil guard always passes) to the choice or
+	// junction pseudo-state pseudoID. Branches are evaluated in declaration order, and targetID can be a state or
+	// another pseudo-state. States switching to pseudoID must list targetID in their allowed transitions, otherwise
+	// Validate reports ErrInvalidTransition, and such transitions are rejected with it.
+	AddBranch(pseudoID StateIdentifier, guard TypedGuard[Event, SmContext], targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// AddTransition declares a transition from sourceID to targetID taken on events of the same dynamic type as
+	// event (nil matches any event) if guard returns true (nil guard always passes). The optional action is executed
+	// between the source state OnExit and the target state OnEnter. Declarative transitions of a state are evaluated
+	// in registration order before its StateAction.Execute call, which is used as a fallback only if none of them
+	// matched. targetID is added to the allowed transitions of sourceID.
+	AddTransition(sourceID StateIdentifier, event Event, guard TypedGuard[Event, SmContext], targetID StateIdentifier, action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// AddInternalTransition declares a transition of sourceID taken on events of the same dynamic type as event (nil
+	// matches any event) if guard returns true (nil guard always passes), which executes the optional action without
+	// leaving the state, so neither OnExit nor OnEnter is called. It is evaluated together with the transitions added
+	// with AddTransition in registration order. A transition added with AddTransition to sourceID itself is an
+	// external one.
+	AddInternalTransition(sourceID StateIdentifier, event Event, guard TypedGuard[Event, SmContext], action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetSelfTransition defines what Execute returning the state's own identifier means for stateID. It is
+	// InternalTransition by default: the state stays active without any callbacks, and, for sub-states, the event is
+	// passed to the parent state. ExternalTransition exits and re-enters the state instead, and stateID is added to
+	// its own allowed transitions.
+	SetSelfTransition(stateID StateIdentifier, kind SelfTransitionKind) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetTransitionAction sets the action executed on transitions from sourceID to targetID which are not declared
+	// with AddTransition: the ones returned by StateAction.Execute, made by ChangeState, state timeouts and completion
+	// transitions. The action is executed after OnExit of the source states and before OnEnter of the target states.
+	// targetID is added to the allowed transitions of sourceID.
+	SetTransitionAction(sourceID StateIdentifier, targetID StateIdentifier, action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// DeferEvent makes stateID defer events of the same dynamic type as event: while stateID or any of its sub-states
+	// is active, such events are queued instead of being dispatched, and are processed again in arrival order after
+	// the next transition, as a part of the call which made it (ProcessEvent, ChangeState or a state timeout).
+	// Events still deferred by the new states stay queued. If processing of a deferred event fails, the call returns
+	// *DeferredEventError (timeouts report it to TypedListener.TimeoutFailed), and the event is dropped. Deferred events are not saved in snapshots, and are dropped by
+	// Reset.
+	DeferEvent(stateID StateIdentifier, event Event) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetDeferLimit sets the number of deferred events kept by the state machine, DefaultDeferLimit by default.
+	// ProcessEvent returns ErrDeferredOverflow for events deferred beyond the limit.
+	SetDeferLimit(limit int) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetStateTimeout makes the state machine switch from stateID to targetID if stateID stays active for d. The timer
+	// is armed on each OnEnter of stateID and cancelled on its OnExit. targetID is added to the allowed transitions of
+	// stateID. The switch is not an event, so it does not pass middlewares, use SetStateTimeoutEvent if it matters. It
+	// is recorded to the event log as RecordTimeout. The state machine is thread-safe, the same way as with
+	// SetThreadSafe(true), as timeouts fire from the Clock goroutines.
+	SetStateTimeout(stateID StateIdentifier, d time.Duration, targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetStateTimeoutEvent is the same as SetStateTimeout, but processes event on timeout instead of switching to
+	// a predefined state, so Execute of the active states decides what to do. The event is logged and persisted the
+	// same way ProcessEvent does, but does not pass middlewares.
+	SetStateTimeoutEvent(stateID StateIdentifier, d time.Duration, event Event) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetClock sets the time source of state timeouts, SystemClock is used by default. FakeClock allows to test
+	// timeouts deterministically.
+	SetClock(clock Clock) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetPanicRecovery makes Start, Stop, Reset, ChangeState and ProcessEvent return *PanicError, and timeouts report
+	// it to TypedListener.TimeoutFailed, instead of panicking if OnEnter, OnExit, Execute, a guard, a transition action or a listener panics. The state machine is
+	// then moved to the error state set with SetErrorState, or, without one, rolled back to the states active before
+	// the call. On rollback, OnEnter of the restored states exited by the call is called again, while the states
+	// entered before the panic are not exited.
+	SetPanicRecovery(enabled bool) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetErrorState sets the state entered after a recovered panic, see SetPanicRecovery. OnExit of the states active
+	// at the moment of the panic is not called. The error state is considered reachable from any state.
+	SetErrorState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetFinalState marks stateID as a final state of the state machine, which is not expected to have a way out.
+	// Reaching a final state on the top level of the states hierarchy finishes the state machine, see
+	// StateMachineHandler.Done. Reaching a final sub-state completes its parent composite state.
+	SetFinalState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetCompletionTransition makes the composite state stateID switch to targetID when it is complete: its active
+	// sub-state is a final one, or, for parallel states, each region is either a final state or a complete composite
+	// state. The transition is taken right after the transition which completed the state, as a part of the same
+	// event processing. targetID is added to the allowed transitions of stateID.
+	SetCompletionTransition(stateID StateIdentifier, targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
 	// SetDefaultState tells which state is the default for the state machine. Each state machine must have a default state.
 	// On StateMachineHandler.Start() call, state machine will switch to the defined default state.
-	SetDefaultState(stateID StateIdentifier) StateMachineBuilder[StateIdentifier]
+	SetDefaultState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetThreadSafe makes the state machine safe for concurrent use. Start, Stop, Reset and ProcessEvent calls are
+	// serialized, and State can be called from any goroutine, including state callbacks. State callbacks must not
+	// call Start, Stop, Reset or ProcessEvent of their own state machine, as it will lead to a deadlock.
+	SetThreadSafe(threadSafe bool) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetMailbox makes the state machine process events asynchronously by a dedicated goroutine running between
+	// Start and Stop calls. Events are delivered with StateMachineHandler.Post or Send calls, and are stored in
+	// a mailbox with the given capacity. ProcessEvent and ProcessEventCtx are equal to Send. The state machine is
+	// thread-safe, the same way as with SetThreadSafe(true).
+	SetMailbox(capacity int, policy OverflowPolicy) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// AddListener registers the listener notified about the state machine activity, see TypedListener for details.
+	// Listeners are notified in registration order.
+	AddListener(listener TypedListener[StateIdentifier, Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// Use adds middlewares wrapping the event processing. Middlewares are called in registration order, the first
+	// registered one is the outermost. Without mailbox, middlewares are called by the goroutine calling
+	// ProcessEvent before the state machine lock is taken, so for thread-safe state machines they can run
+	// concurrently. With mailbox, they are called by the mailbox processing goroutine.
+	Use(middlewares ...TypedMiddleware[Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetContextCodec sets the codec storing the state machine context in snapshots, see StateMachineHandler.Snapshot.
+	SetContextCodec(codec ContextCodec[SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetStore makes the state machine save its snapshots into store, policy defines when they are saved. Only
+	// instances with an ID are persisted: the one set with SetInstanceID for Build, BuildE and Restore, or the one
+	// passed to TypedDefinition.NewPersistentInstance and Load. Snapshots include the context if SetContextCodec
+	// was called.
+	SetStore(store Store[StateIdentifier], policy PersistPolicy) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetInstanceID sets the key of the state machine snapshots in the store set with SetStore.
+	SetInstanceID(id string) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+	// SetEventLog makes the state machine append each processed event and its outcome to log, keyed by the instance
+	// ID the same way as SetStore does. ChangeState and Reset calls and timeout transitions are appended as well, see
+	// RecordKind. Instances sharing the log must have unique IDs. The log allows to rebuild the instance with Replay.
+	SetEventLog(log TypedEventLog[StateIdentifier, Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
 	// SetSmContext is an optional call that allow to pass any context that is unique and persistent (but mutable) for each state machine.
-	SetSmContext(ctx StateMachineContext) StateMachineBuilder[StateIdentifier]
+	SetSmContext(ctx SmContext) TypedStateMachineBuilder[StateIdentifier, Event, SmContext]
+
+	// Validate checks the state machine definition and returns *ValidationError listing all found problems, or nil
+	// if the definition is correct. Besides the problems Build panics on, it reports the default state and
+	// transition targets which are not registered, states unreachable from the default state, and states with no
+	// way out which are not marked final with SetFinalState.
+	Validate() error
 
 	// Build is the final call that aggregates all the data from previous calls and creates new state machine.
-	Build() StateMachineHandler[StateIdentifier]
+	// Build panics if the state machine cannot be created: there are no states, no default state, a state is
+	// registered twice, or the states hierarchy is broken. Use BuildE to get these problems as an error.
+	Build() TypedStateMachineHandler[StateIdentifier, Event, SmContext]
+	// Describe returns the state machine definition for analysis. It can be called before Build, and describes the
+	// definition as is, even if it is not valid.
+	Describe() Description[StateIdentifier]
+	// BuildE creates new state machine the same way as Build does, but returns *ValidationError instead of panicking,
+	// and rejects all the definitions Validate reports.
+	BuildE() (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error)
+	// BuildDefinition creates an immutable state machine definition, which can create any number of state machine
+	// instances sharing the states, see TypedDefinition. The definition is validated the same way as BuildE does, and
+	// is not affected by further builder calls. SetSmContext value is not used, as each instance gets its own one.
+	BuildDefinition() (*TypedDefinition[StateIdentifier, Event, SmContext], error)
+	// Restore creates new state machine the same way as Build does, but in the state saved in the snapshot instead of
+	// the default one, see TypedDefinition.Restore for details. The context set by SetSmContext is used if the
+	// snapshot has no encoded context.
+	Restore(snapshot Snapshot[StateIdentifier], mode RestoreMode) (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error)
+	// Replay creates new state machine the same way as Build does, and replays the events logged for the instance ID
+	// set with SetInstanceID, see TypedDefinition.Replay for details.
+	Replay() (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error)
 }
 
+// StateMachineBuilder is the TypedStateMachineBuilder for states accepting any EventContext and StateMachineContext.
+type StateMachineBuilder[StateIdentifier comparable] = TypedStateMachineBuilder[StateIdentifier, EventContext, StateMachineContext]
+
 // NewBuilder function generates StateMachineBuilder which simplifies state machine creation process.
 func NewBuilder[StateIdentifier comparable]() StateMachineBuilder[StateIdentifier] {
-	return &stateMachineBuilder[StateIdentifier]{
+	return NewTypedBuilder[StateIdentifier, EventContext, StateMachineContext]()
+}
+
+// NewTypedBuilder function generates TypedStateMachineBuilder for states with Event type of events and SmContext type
+// of the state machine context.
+func NewTypedBuilder[StateIdentifier comparable, Event any, SmContext any]() TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	return &stateMachineBuilder[StateIdentifier, Event, SmContext]{
 		hasState: false,
-		sm: &stateMachine[StateIdentifier]{
-			states: StatesMap[StateIdentifier]{},
+		def: &definition[StateIdentifier, Event, SmContext]{
+			states:     TypedStatesMap[StateIdentifier, Event, SmContext]{},
+			deferLimit: DefaultDeferLimit,
 		},
+		defaultSubStates: map[StateIdentifier]StateIdentifier{},
+		parallelStates:   map[StateIdentifier]struct{}{},
+		finalStates:      map[StateIdentifier]struct{}{},
+		guarded:          map[StateIdentifier][]guardedTransition[StateIdentifier, Event, SmContext]{},
+		transitionOrder:  map[StateIdentifier][]StateIdentifier{},
+		timeouts:         map[StateIdentifier]stateTimeout[StateIdentifier, Event]{},
+		completions:      map[StateIdentifier]completion[StateIdentifier]{},
+		pseudo:           map[StateIdentifier]pseudoState[StateIdentifier, Event, SmContext]{},
+		selfTransitions:  map[StateIdentifier]SelfTransitionKind{},
+		actions:          map[StateIdentifier]map[StateIdentifier]TypedTransitionAction[Event, SmContext]{},
+		deferred:         map[StateIdentifier][]reflect.Type{},
+		branches:         map[StateIdentifier][]branch[StateIdentifier, Event, SmContext]{},
 	}
 }
 
-// stateMachineBuilder[StateIdentifier comparable] is an implementation for
-// the StateMachineBuilder[StateIdentifier comparable] interface
-type stateMachineBuilder[StateIdentifier comparable] struct {
+// stateMachineBuilder[StateIdentifier comparable, Event any, SmContext any] is an implementation for
+// the TypedStateMachineBuilder[StateIdentifier comparable, Event any, SmContext any] interface
+type stateMachineBuilder[StateIdentifier comparable, Event any, SmContext any] struct {
 	hasState        bool
 	hasDefaultState bool
 
-	sm *stateMachine[StateIdentifier]
+	defaultSubStates map[StateIdentifier]StateIdentifier
+	parallelStates   map[StateIdentifier]struct{}
+	finalStates      map[StateIdentifier]struct{}
+	// registered keeps states registration order, which defines the regions order
+	registered []StateIdentifier
+	// guarded keeps declarative transitions per source state until Build call
+	guarded map[StateIdentifier][]guardedTransition[StateIdentifier, Event, SmContext]
+
+	// guardedOrder, finalOrder and transitionOrder keep the declaration order to report problems deterministically
+	guardedOrder    []StateIdentifier
+	finalOrder      []StateIdentifier
+	transitionOrder map[StateIdentifier][]StateIdentifier
+	// timeouts keeps state timeouts until Build call, timeoutOrder keeps their declaration order
+	timeouts     map[StateIdentifier]stateTimeout[StateIdentifier, Event]
+	timeoutOrder []StateIdentifier
+	// completions keeps completion transitions until Build call, completionOrder keeps their declaration order
+	completions     map[StateIdentifier]completion[StateIdentifier]
+	completionOrder []StateIdentifier
+	// pseudo keeps pseudo-states until Build call, pseudoOrder keeps their registration order
+	pseudo      map[StateIdentifier]pseudoState[StateIdentifier, Event, SmContext]
+	pseudoOrder []StateIdentifier
+	// branches keeps choice and junction branches until Build call, branchOrder keeps their sources declaration order
+	branches    map[StateIdentifier][]branch[StateIdentifier, Event, SmContext]
+	branchOrder []StateIdentifier
+	// selfTransitions keeps self-transition kinds until Build call, selfOrder keeps their declaration order
+	selfTransitions map[StateIdentifier]SelfTransitionKind
+	selfOrder       []StateIdentifier
+	// actions keeps transition actions per source and target state until Build call, actionOrder keeps their sources
+	// declaration order
+	actions     map[StateIdentifier]map[StateIdentifier]TypedTransitionAction[Event, SmContext]
+	actionOrder []StateIdentifier
+	// deferred keeps deferred event types per state until Build call, deferOrder keeps their declaration order
+	deferred   map[StateIdentifier][]reflect.Type
+	deferOrder []StateIdentifier
+	// problems are found during states registration
+	problems []*DefinitionError[StateIdentifier]
+
+	def   *definition[StateIdentifier, Event, SmContext]
+	smCtx SmContext
+	id    string
 }
 
-func (s *stateMachineBuilder[StateIdentifier]) Build() StateMachineHandler[StateIdentifier] {
-	if !s.hasState || !s.hasDefaultState {
-		panic("state machine is not properly initialised yet")
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Build() TypedStateMachineHandler[StateIdentifier, Event, SmContext] {
+	if err := validationError(s.link()); err != nil {
+		panic(err)
 	}
-	return s.sm
+	sm := s.def.clone().newInstance(s.smCtx)
+	sm.setID(s.id)
+	return sm
 }
 
-func (s *stateMachineBuilder[StateIdentifier]) RegisterState(
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) BuildE() (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	sm := s.def.clone().newInstance(s.smCtx)
+	sm.setID(s.id)
+	return sm, nil
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) BuildDefinition() (*TypedDefinition[StateIdentifier, Event, SmContext], error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &TypedDefinition[StateIdentifier, Event, SmContext]{def: s.def.clone()}, nil
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Restore(
+	snapshot Snapshot[StateIdentifier],
+	mode RestoreMode) (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+
+	if err := validationError(s.link()); err != nil {
+		return nil, err
+	}
+	sm, err := s.def.clone().restore(snapshot, s.smCtx, mode)
+	if err != nil {
+		return nil, err
+	}
+	sm.setID(s.id)
+	return sm, nil
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Replay() (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+	if err := validationError(s.link()); err != nil {
+		return nil, err
+	}
+	return s.def.clone().replay(s.id, s.smCtx)
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Validate() error {
+	problems := s.link()
+	if len(problems) == 0 {
+		problems = s.checkDefinition()
+	}
+	return validationError(problems)
+}
+
+// link verifies the definition and completes the states with the hierarchy and declarative transitions data.
+// It can be called more than once.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) link() []*DefinitionError[StateIdentifier] {
+	var none StateIdentifier
+	problems := append([]*DefinitionError[StateIdentifier](nil), s.problems...)
+	if !s.hasState {
+		problems = append(problems, newDefinitionError(none, ErrNoStates, "no states registered"))
+	}
+	if !s.hasDefaultState {
+		problems = append(problems, newDefinitionError(none, ErrNoDefaultState, "default state is not set"))
+	}
+	problems = append(problems, s.linkSubStates()...)
+	problems = append(problems, s.linkPseudoStates()...)
+	problems = append(problems, s.linkTransitions()...)
+	problems = append(problems, s.linkTimeouts()...)
+	problems = append(problems, s.linkSelfTransitions()...)
+	problems = append(problems, s.linkTransitionActions()...)
+	problems = append(problems, s.linkDeferred()...)
+	if len(problems) == 0 {
+		// completions need the linked hierarchy
+		problems = append(problems, s.linkCompletions()...)
+	}
+	for stateID := range s.finalStates {
+		if st, ok := s.def.states[stateID]; ok {
+			st.final = true
+			s.def.states[stateID] = st
+		}
+	}
+	for _, stateID := range s.registered {
+		st := s.def.states[stateID]
+		st.targets = nil
+		seen := map[StateIdentifier]struct{}{}
+		for _, targetID := range s.transitionOrder[stateID] {
+			if _, ok := seen[targetID]; !ok {
+				seen[targetID] = struct{}{}
+				st.targets = append(st.targets, targetID)
+			}
+		}
+		s.def.states[stateID] = st
+	}
+	s.def.order = s.registered
+	return problems
+}
+
+// linkTransitions attaches declarative transitions to their source states.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkTransitions() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, sourceID := range s.guardedOrder {
+		transitions := s.guarded[sourceID]
+		source, ok := s.def.states[sourceID]
+		if !ok {
+			problems = append(problems, newDefinitionError(sourceID, ErrStateNotRegistered,
+				"transition source state %v is not registered", sourceID))
+			continue
+		}
+		source.guarded = transitions
+		for _, transition := range transitions {
+			if !transition.internal {
+				source.transitions[transition.targetID] = struct{}{}
+			}
+		}
+		s.def.states[sourceID] = source
+	}
+	return problems
+}
+
+// linkTimeouts attaches timeouts to their states.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkTimeouts() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, stateID := range s.timeoutOrder {
+		st, ok := s.def.states[stateID]
+		if !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"timeout state %v is not registered", stateID))
+			continue
+		}
+		st.timeout = s.timeouts[stateID]
+		st.hasTimeout = true
+		if !st.timeout.hasEvent {
+			st.transitions[st.timeout.targetID] = struct{}{}
+		}
+		s.def.states[stateID] = st
+		s.def.hasTimeouts = true
+	}
+	if s.def.clock == nil {
+		s.def.clock = SystemClock{}
+	}
+	return problems
+}
+
+// linkSelfTransitions marks states with external self-transitions.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkSelfTransitions() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, stateID := range s.selfOrder {
+		st, ok := s.def.states[stateID]
+		if !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"self-transition state %v is not registered", stateID))
+			continue
+		}
+		st.externalSelf = s.selfTransitions[stateID] == ExternalTransition
+		if st.externalSelf {
+			st.transitions[stateID] = struct{}{}
+		}
+		s.def.states[stateID] = st
+	}
+	return problems
+}
+
+// linkTransitionActions attaches transition actions to their source states.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkTransitionActions() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, stateID := range s.actionOrder {
+		st, ok := s.def.states[stateID]
+		if !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"transition action state %v is not registered", stateID))
+			continue
+		}
+		// the definition must not change with further SetTransitionAction calls
+		st.actions = make(map[StateIdentifier]TypedTransitionAction[Event, SmContext], len(s.actions[stateID]))
+		for targetID, action := range s.actions[stateID] {
+			st.actions[targetID] = action
+			st.transitions[targetID] = struct{}{}
+		}
+		s.def.states[stateID] = st
+	}
+	return problems
+}
+
+// linkDeferred attaches deferred event types to their states.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkDeferred() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, stateID := range s.deferOrder {
+		st, ok := s.def.states[stateID]
+		if !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"deferring state %v is not registered", stateID))
+			continue
+		}
+		st.deferred = map[reflect.Type]struct{}{}
+		for _, eventType := range s.deferred[stateID] {
+			st.deferred[eventType] = struct{}{}
+		}
+		s.def.states[stateID] = st
+		s.def.hasDeferred = true
+	}
+	return problems
+}
+
+// linkCompletions attaches completion transitions to their composite states.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkCompletions() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, stateID := range s.completionOrder {
+		st, ok := s.def.states[stateID]
+		if !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"completion transition state %v is not registered", stateID))
+			continue
+		}
+		if !st.composite {
+			problems = append(problems, newDefinitionError(stateID, ErrInvalidHierarchy,
+				"completion transition state %v is not a composite state", stateID))
+			continue
+		}
+		st.completion = s.completions[stateID]
+		st.hasCompletion = true
+		st.transitions[st.completion.targetID] = struct{}{}
+		s.def.states[stateID] = st
+	}
+	return problems
+}
+
+// linkPseudoStates verifies pseudo-states and adds them to the definition.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkPseudoStates() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	s.def.pseudo = nil
+	s.def.pseudoOrder = nil
+	s.def.hasHistory = false
+	for _, pseudoID := range s.branchOrder {
+		if pseudo, ok := s.pseudo[pseudoID]; !ok || !pseudo.isBranching() {
+			problems = append(problems, newDefinitionError(pseudoID, ErrStateNotRegistered,
+				"branch source %v is not a registered choice or junction", pseudoID))
+		}
+	}
+	for _, pseudoID := range s.pseudoOrder {
+		pseudo := s.pseudo[pseudoID]
+		if _, ok := s.def.states[pseudoID]; ok {
+			problems = append(problems, newDefinitionError(pseudoID, ErrDuplicateState,
+				"pseudo-state %v is registered as a state", pseudoID))
+			continue
+		}
+		if pseudo.isBranching() {
+			pseudo.branches = s.branches[pseudoID]
+			if problem := s.checkBranches(pseudoID, pseudo); problem != nil {
+				problems = append(problems, problem)
+				continue
+			}
+		} else if problem := s.checkHistory(pseudoID, pseudo); problem != nil {
+			problems = append(problems, problem)
+			continue
+		}
+		if s.def.pseudo == nil {
+			s.def.pseudo = map[StateIdentifier]pseudoState[StateIdentifier, Event, SmContext]{}
+		}
+		s.def.pseudo[pseudoID] = pseudo
+		s.def.pseudoOrder = append(s.def.pseudoOrder, pseudoID)
+		s.def.hasHistory = s.def.hasHistory || !pseudo.isBranching()
+	}
+	if len(problems) == 0 {
+		problems = append(problems, s.checkJunctionCycles()...)
+	}
+	return problems
+}
+
+// checkHistory verifies the history pseudo-state.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) checkHistory(
+	pseudoID StateIdentifier,
+	pseudo pseudoState[StateIdentifier, Event, SmContext]) *DefinitionError[StateIdentifier] {
+
+	parent, ok := s.def.states[pseudo.parent]
+	if !ok {
+		return newDefinitionError(pseudo.parent, ErrStateNotRegistered,
+			"parent state %v of %v is not registered", pseudo.parent, pseudoID)
+	}
+	if !parent.composite {
+		return newDefinitionError(pseudoID, ErrInvalidHierarchy,
+			"history state %v belongs to %v which is not a composite state", pseudoID, pseudo.parent)
+	}
+	if pseudo.kind != ShallowHistory && pseudo.kind != DeepHistory {
+		return newDefinitionError(pseudoID, ErrInvalidHierarchy,
+			"history state %v has invalid kind %v", pseudoID, pseudo.kind)
+	}
+	return nil
+}
+
+// checkBranches verifies the choice or junction pseudo-state. A choice must have a branch without guard, as its
+// guards are evaluated after the source states are exited, and it cannot lead to another choice or junction.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) checkBranches(
+	pseudoID StateIdentifier,
+	pseudo pseudoState[StateIdentifier, Event, SmContext]) *DefinitionError[StateIdentifier] {
+
+	if len(pseudo.branches) == 0 {
+		return newDefinitionError(pseudoID, ErrInvalidPseudoState, "%v %v has no branches", pseudo.kind, pseudoID)
+	}
+	if pseudo.kind == Junction {
+		return nil
+	}
+	hasDefault := false
+	for _, b := range pseudo.branches {
+		if target, ok := s.pseudo[b.targetID]; ok && target.isBranching() {
+			return newDefinitionError(pseudoID, ErrInvalidPseudoState,
+				"choice %v leads to %v %v", pseudoID, target.kind, b.targetID)
+		}
+		hasDefault = hasDefault || b.guard == nil
+	}
+	if !hasDefault {
+		return newDefinitionError(pseudoID, ErrInvalidPseudoState, "choice %v has no branch without guard", pseudoID)
+	}
+	return nil
+}
+
+// checkJunctionCycles reports junctions leading to themselves through other junctions.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) checkJunctionCycles() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	for _, pseudoID := range s.def.pseudoOrder {
+		if s.def.pseudo[pseudoID].kind != Junction {
+			continue
+		}
+		visited := map[StateIdentifier]bool{}
+		queue := []StateIdentifier{pseudoID}
+		for len(queue) > 0 && !visited[pseudoID] {
+			pseudo := s.def.pseudo[queue[0]]
+			queue = queue[1:]
+			for _, b := range pseudo.branches {
+				if target, ok := s.def.pseudo[b.targetID]; ok && target.kind == Junction && !visited[b.targetID] {
+					visited[b.targetID] = true
+					queue = append(queue, b.targetID)
+				}
+			}
+		}
+		if visited[pseudoID] {
+			problems = append(problems, newDefinitionError(pseudoID, ErrInvalidPseudoState,
+				"junction %v leads to itself", pseudoID))
+		}
+	}
+	return problems
+}
+
+// linkSubStates verifies the states hierarchy and marks states with registered sub-states as composite ones.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) linkSubStates() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	children := map[StateIdentifier][]StateIdentifier{}
+	// parents keeps composite states in registration order of their first sub-state
+	var parents []StateIdentifier
+	for _, stateID := range s.registered {
+		st := s.def.states[stateID]
+		if !st.hasParent {
+			continue
+		}
+		if _, ok := s.def.states[st.parent]; !ok {
+			problems = append(problems, newDefinitionError(st.parent, ErrStateNotRegistered,
+				"parent state %v of %v is not registered", st.parent, stateID))
+			continue
+		}
+		if _, ok := children[st.parent]; !ok {
+			parents = append(parents, st.parent)
+		}
+		children[st.parent] = append(children[st.parent], stateID)
+		// a parent chain longer than the number of states means that the hierarchy has a loop
+		depth := 0
+		for parentID, ok := s.def.parentOf(stateID); ok; parentID, ok = s.def.parentOf(parentID) {
+			depth++
+			if depth > len(s.def.states) {
+				problems = append(problems, newDefinitionError(stateID, ErrInvalidHierarchy,
+					"state %v has cyclic parent chain", stateID))
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	for _, parallelID := range s.registered {
+		if _, ok := s.parallelStates[parallelID]; ok && len(children[parallelID]) == 0 {
+			problems = append(problems, newDefinitionError(parallelID, ErrInvalidHierarchy,
+				"parallel state %v has no regions", parallelID))
+		}
+	}
+	for parallelID := range s.parallelStates {
+		if _, ok := s.def.states[parallelID]; !ok {
+			problems = append(problems, newDefinitionError(parallelID, ErrStateNotRegistered,
+				"parallel state %v is not registered", parallelID))
+		}
+	}
+
+	for _, parentID := range parents {
+		subStates := children[parentID]
+		parent := s.def.states[parentID]
+		parent.composite = true
+		if _, ok := s.parallelStates[parentID]; ok {
+			parent.parallel = true
+			parent.regions = subStates
+			s.def.states[parentID] = parent
+			continue
+		}
+
+		subStateID, ok := s.defaultSubStates[parentID]
+		if !ok {
+			problems = append(problems, newDefinitionError(parentID, ErrInvalidHierarchy,
+				"composite state %v has no default sub-state", parentID))
+			continue
+		}
+		subState, ok := s.def.states[subStateID]
+		if !ok || !subState.hasParent || subState.parent != parentID {
+			problems = append(problems, newDefinitionError(subStateID, ErrInvalidHierarchy,
+				"default sub-state %v is not a sub-state of %v", subStateID, parentID))
+			continue
+		}
+		parent.defaultSubState = subStateID
+		s.def.states[parentID] = parent
+	}
+	return problems
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) RegisterState(
+	stateID StateIdentifier,
+	action TypedStateAction[StateIdentifier, Event, SmContext],
+	transitions []StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	s.register(stateID, action, transitions)
+
+	return s
+}
+
+// register adds the state to the state machine. Duplicates are reported as a problem and ignored.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) register(
 	stateID StateIdentifier,
-	action StateAction[StateIdentifier],
-	transitions []StateIdentifier) StateMachineBuilder[StateIdentifier] {
+	action TypedStateAction[StateIdentifier, Event, SmContext],
+	transitions []StateIdentifier) bool {
 
-	_, ok := s.sm.states[stateID]
-	if ok {
-		panic(fmt.Sprintf("state %v is already registered", stateID))
+	if _, ok := s.def.states[stateID]; ok {
+		s.problems = append(s.problems, newDefinitionError(stateID, ErrDuplicateState,
+			"state %v is already registered", stateID))
+		return false
 	}
 
-	s.sm.states[stateID] = state[StateIdentifier]{
+	s.def.states[stateID] = state[StateIdentifier, Event, SmContext]{
 		action:      action,
 		transitions: makeTransitions(transitions),
 	}
+	s.registered = append(s.registered, stateID)
+	s.transitionOrder[stateID] = append(s.transitionOrder[stateID], transitions...)
 	s.hasState = true
 
+	return true
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) RegisterSubState(
+	parentID StateIdentifier,
+	stateID StateIdentifier,
+	action TypedStateAction[StateIdentifier, Event, SmContext],
+	transitions []StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if !s.register(stateID, action, transitions) {
+		return s
+	}
+
+	subState := s.def.states[stateID]
+	subState.parent = parentID
+	subState.hasParent = true
+	s.def.states[stateID] = subState
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) RegisterHistoryState(
+	parentID StateIdentifier,
+	historyID StateIdentifier,
+	kind PseudoStateKind) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	s.registerPseudo(historyID, pseudoState[StateIdentifier, Event, SmContext]{kind: kind, parent: parentID})
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) RegisterChoiceState(choiceID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.registerPseudo(choiceID, pseudoState[StateIdentifier, Event, SmContext]{kind: Choice})
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) RegisterJunctionState(junctionID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.registerPseudo(junctionID, pseudoState[StateIdentifier, Event, SmContext]{kind: Junction})
+
+	return s
+}
+
+// registerPseudo adds the pseudo-state to the state machine. Duplicates are reported as a problem and ignored.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) registerPseudo(
+	pseudoID StateIdentifier,
+	pseudo pseudoState[StateIdentifier, Event, SmContext]) {
+
+	if _, ok := s.pseudo[pseudoID]; ok {
+		s.problems = append(s.problems, newDefinitionError(pseudoID, ErrDuplicateState,
+			"pseudo-state %v is already registered", pseudoID))
+		return
+	}
+	s.pseudo[pseudoID] = pseudo
+	s.pseudoOrder = append(s.pseudoOrder, pseudoID)
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) AddBranch(
+	pseudoID StateIdentifier,
+	guard TypedGuard[Event, SmContext],
+	targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if _, ok := s.branches[pseudoID]; !ok {
+		s.branchOrder = append(s.branchOrder, pseudoID)
+	}
+	s.branches[pseudoID] = append(s.branches[pseudoID], branch[StateIdentifier, Event, SmContext]{guard: guard, targetID: targetID})
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetParallelState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.parallelStates[stateID] = struct{}{}
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetDefaultSubState(parentID StateIdentifier, stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.defaultSubStates[parentID] = stateID
+
 	return s
 }
 
@@ -74,22 +792,221 @@ func makeTransitions[StateIdentifier comparable](transitions []StateIdentifier)
 	return trs
 }
 
-func (s *stateMachineBuilder[StateIdentifier]) SetDefaultState(stateID StateIdentifier) StateMachineBuilder[StateIdentifier] {
-	s.sm.currentStateID = stateID
-	s.sm.defaultStateID = stateID
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) AddTransition(
+	sourceID StateIdentifier,
+	event Event,
+	guard TypedGuard[Event, SmContext],
+	targetID StateIdentifier,
+	action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if _, ok := s.guarded[sourceID]; !ok {
+		s.guardedOrder = append(s.guardedOrder, sourceID)
+	}
+	s.transitionOrder[sourceID] = append(s.transitionOrder[sourceID], targetID)
+	s.guarded[sourceID] = append(s.guarded[sourceID], guardedTransition[StateIdentifier, Event, SmContext]{
+		eventType: reflect.TypeOf(event),
+		guard:     guard,
+		targetID:  targetID,
+		action:    action,
+	})
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) AddInternalTransition(
+	sourceID StateIdentifier,
+	event Event,
+	guard TypedGuard[Event, SmContext],
+	action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if _, ok := s.guarded[sourceID]; !ok {
+		s.guardedOrder = append(s.guardedOrder, sourceID)
+	}
+	s.guarded[sourceID] = append(s.guarded[sourceID], guardedTransition[StateIdentifier, Event, SmContext]{
+		eventType: reflect.TypeOf(event),
+		guard:     guard,
+		action:    action,
+		internal:  true,
+	})
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetSelfTransition(stateID StateIdentifier, kind SelfTransitionKind) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	if _, ok := s.selfTransitions[stateID]; !ok {
+		s.selfOrder = append(s.selfOrder, stateID)
+	}
+	s.selfTransitions[stateID] = kind
+	if kind == ExternalTransition {
+		s.transitionOrder[stateID] = append(s.transitionOrder[stateID], stateID)
+	}
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetTransitionAction(
+	sourceID StateIdentifier,
+	targetID StateIdentifier,
+	action TypedTransitionAction[Event, SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if _, ok := s.actions[sourceID]; !ok {
+		s.actionOrder = append(s.actionOrder, sourceID)
+		s.actions[sourceID] = map[StateIdentifier]TypedTransitionAction[Event, SmContext]{}
+	}
+	s.actions[sourceID][targetID] = action
+	s.transitionOrder[sourceID] = append(s.transitionOrder[sourceID], targetID)
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) DeferEvent(stateID StateIdentifier, event Event) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	if _, ok := s.deferred[stateID]; !ok {
+		s.deferOrder = append(s.deferOrder, stateID)
+	}
+	s.deferred[stateID] = append(s.deferred[stateID], reflect.TypeOf(event))
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetDeferLimit(limit int) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.deferLimit = limit
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetStateTimeout(
+	stateID StateIdentifier,
+	d time.Duration,
+	targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	s.transitionOrder[stateID] = append(s.transitionOrder[stateID], targetID)
+	return s.setTimeout(stateID, stateTimeout[StateIdentifier, Event]{duration: d, targetID: targetID})
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetStateTimeoutEvent(
+	stateID StateIdentifier,
+	d time.Duration,
+	event Event) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	return s.setTimeout(stateID, stateTimeout[StateIdentifier, Event]{duration: d, event: event, hasEvent: true})
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) setTimeout(
+	stateID StateIdentifier,
+	timeout stateTimeout[StateIdentifier, Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+
+	if _, ok := s.timeouts[stateID]; !ok {
+		s.timeoutOrder = append(s.timeoutOrder, stateID)
+	}
+	s.timeouts[stateID] = timeout
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetClock(clock Clock) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.clock = clock
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetPanicRecovery(enabled bool) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.recoverPanics = enabled
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetErrorState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.errorStateID = stateID
+	s.def.hasErrorState = true
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetFinalState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	if _, ok := s.finalStates[stateID]; !ok {
+		s.finalStates[stateID] = struct{}{}
+		s.finalOrder = append(s.finalOrder, stateID)
+	}
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetCompletionTransition(stateID StateIdentifier, targetID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	if _, ok := s.completions[stateID]; !ok {
+		s.completionOrder = append(s.completionOrder, stateID)
+	}
+	s.completions[stateID] = completion[StateIdentifier]{targetID: targetID}
+	s.transitionOrder[stateID] = append(s.transitionOrder[stateID], targetID)
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetDefaultState(stateID StateIdentifier) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.defaultStateID = stateID
 	s.hasDefaultState = true
 
 	return s
 }
 
-func (s *stateMachineBuilder[StateIdentifier]) SetSmContext(ctx StateMachineContext) StateMachineBuilder[StateIdentifier] {
-	s.sm.smCtx = ctx
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetContextCodec(codec ContextCodec[SmContext]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.codec = codec
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetStore(store Store[StateIdentifier], policy PersistPolicy) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.store = store
+	s.def.persistPolicy = policy
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetInstanceID(id string) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.id = id
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetEventLog(log TypedEventLog[StateIdentifier, Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.eventLog = log
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetSmContext(ctx SmContext) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.smCtx = ctx
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetThreadSafe(threadSafe bool) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.threadSafe = threadSafe
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetMailbox(capacity int, policy OverflowPolicy) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.hasMailbox = true
+	s.def.mailboxCapacity = capacity
+	s.def.mailboxPolicy = policy
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) AddListener(listener TypedListener[StateIdentifier, Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.listeners = append(s.def.listeners, &listener)
+
+	return s
+}
+
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) Use(middlewares ...TypedMiddleware[Event]) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.middlewares = append(s.def.middlewares, middlewares...)
 
 	return s
 }
 
-func (s *stateMachineBuilder[StateIdentifier]) SetSMName(smName string) StateMachineBuilder[StateIdentifier] {
-	s.sm.name = smName
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) SetSMName(smName string) TypedStateMachineBuilder[StateIdentifier, Event, SmContext] {
+	s.def.name = smName
 
 	return s
 }
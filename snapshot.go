@@ -0,0 +1,207 @@
+package gfsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ErrInvalidSnapshot is returned by Restore calls if the snapshot does not match the state machine definition.
+var ErrInvalidSnapshot = fmt.Errorf("invalid snapshot")
+
+// Snapshot is a serializable state of a state machine instance returned by StateMachineHandler.Snapshot.
+type Snapshot[StateIdentifier comparable] struct {
+	// Name is the state machine name set by StateMachineBuilder.SetSMName.
+	Name string `json:"name,omitempty"`
+	// State is the value State() returned at the snapshot moment.
+	State StateIdentifier `json:"state"`
+	// ActiveStates are the active leaf states of orthogonal regions, it is empty without active regions.
+	ActiveStates []StateIdentifier `json:"active_states,omitempty"`
+	// History is the last active sub-state of each exited composite state, which is used by history pseudo-states.
+	History []HistoryEntry[StateIdentifier] `json:"history,omitempty"`
+	// Context is the state machine context encoded with ContextCodec, it is nil if there is no codec.
+	Context []byte `json:"context,omitempty"`
+}
+
+// ContextCodec converts the state machine context to bytes and back for snapshots.
+type ContextCodec[SmContext any] interface {
+	Encode(smCtx SmContext) ([]byte, error)
+	Decode(data []byte) (SmContext, error)
+}
+
+// JSONCodec is the ContextCodec using encoding/json.
+type JSONCodec[SmContext any] struct{}
+
+func (JSONCodec[SmContext]) Encode(smCtx SmContext) ([]byte, error) {
+	return json.Marshal(smCtx)
+}
+
+func (JSONCodec[SmContext]) Decode(data []byte) (SmContext, error) {
+	var smCtx SmContext
+	err := json.Unmarshal(data, &smCtx)
+	return smCtx, err
+}
+
+// RestoreMode defines whether OnEnter callbacks are called for the restored states.
+type RestoreMode int
+
+const (
+	// RestoreSkipOnEnter restores the active states silently, as if they were entered before. This is the mode for
+	// state machines resuming after a restart, as OnEnter side effects have already happened.
+	RestoreSkipOnEnter RestoreMode = iota
+	// RestoreRunOnEnter calls OnEnter of all the restored active states, from the outermost to the innermost ones,
+	// regions in registration order. This is the mode for states which set up runtime resources on entering.
+	RestoreRunOnEnter
+)
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) Snapshot() (Snapshot[StateIdentifier], error) {
+	s.lockEvents()
+	defer s.unlockEvents()
+	return s.snapshot()
+}
+
+func (s *stateMachine[StateIdentifier, Event, SmContext]) snapshot() (Snapshot[StateIdentifier], error) {
+	snapshot := Snapshot[StateIdentifier]{
+		Name:         s.name,
+		State:        s.currentStateID,
+		ActiveStates: append([]StateIdentifier(nil), s.activeLeaves...),
+		History:      s.historyEntries(),
+	}
+	if s.codec != nil {
+		data, err := s.codec.Encode(s.smCtx)
+		if err != nil {
+			return Snapshot[StateIdentifier]{}, fmt.Errorf("cannot encode state machine context: %w", err)
+		}
+		snapshot.Context = data
+	}
+	return snapshot, nil
+}
+
+// Restore creates new state machine instance in the state saved in the snapshot. The instance is already started,
+// so Start must not be called, and the default state is not entered. mode defines whether OnEnter of the restored
+// states is called. If the snapshot has an encoded context and the definition has a ContextCodec, the decoded
+// context is used, smCtx is used otherwise. Timeouts of the restored states are armed with their full duration in
+// both modes.
+func (d *TypedDefinition[StateIdentifier, Event, SmContext]) Restore(
+	snapshot Snapshot[StateIdentifier],
+	smCtx SmContext,
+	mode RestoreMode) (TypedStateMachineHandler[StateIdentifier, Event, SmContext], error) {
+
+	return d.def.restore(snapshot, smCtx, mode)
+}
+
+func (d *definition[StateIdentifier, Event, SmContext]) restore(
+	snapshot Snapshot[StateIdentifier],
+	smCtx SmContext,
+	mode RestoreMode) (*stateMachine[StateIdentifier, Event, SmContext], error) {
+
+	if snapshot.Name != d.name {
+		return nil, fmt.Errorf("snapshot of %q cannot be restored into %q: %w", snapshot.Name, d.name, ErrInvalidSnapshot)
+	}
+	if err := d.checkConfiguration(snapshot.State, snapshot.ActiveStates); err != nil {
+		return nil, err
+	}
+	if err := d.checkHistory(snapshot.History); err != nil {
+		return nil, err
+	}
+	if snapshot.Context != nil && d.codec != nil {
+		decoded, err := d.codec.Decode(snapshot.Context)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode state machine context: %w", err)
+		}
+		smCtx = decoded
+	}
+
+	s := d.newInstance(smCtx)
+	s.lockEvents()
+	defer s.unlockEvents()
+	s.setStatus(StatusRunning)
+
+	leaves := leafSet[StateIdentifier]{}
+	if len(snapshot.ActiveStates) == 0 {
+		leaves.add(snapshot.State)
+	}
+	for _, leafID := range snapshot.ActiveStates {
+		leaves.add(leafID)
+	}
+	s.setConfiguration(leaves)
+	for _, entry := range snapshot.History {
+		x := s.extra()
+		if x.history == nil {
+			x.history = map[StateIdentifier]StateIdentifier{}
+		}
+		x.history[entry.State] = entry.SubState
+	}
+
+	ctx := context.Background()
+	s.reenter(ctx, mode == RestoreRunOnEnter)
+	s.startMailbox()
+
+	var noEvent Event
+	var noState StateIdentifier
+	s.notify(ctx, hookStarted, noState, s.currentStateID, noEvent, nil)
+	if s.isFinal() {
+		s.finish(ctx, noEvent)
+	}
+	return s, nil
+}
+
+// checkConfiguration verifies that the active configuration consists of registered leaf states, and stateID is
+// the innermost state containing all of them.
+func (d *definition[StateIdentifier, Event, SmContext]) checkConfiguration(stateID StateIdentifier, leaves []StateIdentifier) error {
+	if _, ok := d.states[stateID]; !ok {
+		return fmt.Errorf("state %v is not registered: %w", stateID, ErrInvalidSnapshot)
+	}
+	if len(leaves) == 0 {
+		if d.states[stateID].composite {
+			return fmt.Errorf("state %v is not a leaf state: %w", stateID, ErrInvalidSnapshot)
+		}
+		return nil
+	}
+	if !d.states[stateID].parallel {
+		return fmt.Errorf("state %v has no regions: %w", stateID, ErrInvalidSnapshot)
+	}
+	for _, leafID := range leaves {
+		st, ok := d.states[leafID]
+		if !ok || st.composite {
+			return fmt.Errorf("state %v is not a registered leaf state: %w", leafID, ErrInvalidSnapshot)
+		}
+		inside := false
+		for parentID, ok := d.parentOf(leafID); ok && !inside; parentID, ok = d.parentOf(parentID) {
+			inside = parentID == stateID
+		}
+		if !inside {
+			return fmt.Errorf("state %v is not inside %v: %w", leafID, stateID, ErrInvalidSnapshot)
+		}
+	}
+	return nil
+}
+
+// reenter arms timeouts of all active states and calls their OnEnter if runOnEnter is set, each state is entered
+// before its sub-states.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) reenter(ctx context.Context, runOnEnter bool) {
+	leaves := s.activeLeaves
+	if leaves == nil {
+		leaves = []StateIdentifier{s.currentStateID}
+	}
+	entered := map[StateIdentifier]struct{}{}
+	for _, leafID := range leaves {
+		var branch []StateIdentifier
+		for stateID, ok := leafID, true; ok; stateID, ok = s.parentOf(stateID) {
+			if _, done := entered[stateID]; done {
+				break
+			}
+			branch = append(branch, stateID)
+		}
+		for i := len(branch) - 1; i >= 0; i-- {
+			entered[branch[i]] = struct{}{}
+			if runOnEnter {
+				st := s.states[branch[i]]
+				s.enterSite(branch[i], PhaseOnEnter)
+				st.onEnter(ctx, s.smCtx)
+				s.leaveSite()
+			}
+			s.startTimer(branch[i])
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package gfsm
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counterCodec stores counterContext value, the limit is a part of the definition.
+type counterCodec struct {
+	limit int
+}
+
+func (c counterCodec) Encode(smCtx *counterContext) ([]byte, error) {
+	return json.Marshal(smCtx.value)
+}
+
+func (c counterCodec) Decode(data []byte) (*counterContext, error) {
+	smCtx := &counterContext{limit: c.limit}
+	err := json.Unmarshal(data, &smCtx.value)
+	return smCtx, err
+}
+
+// jsonRoundTrip passes the snapshot through JSON encoding, as a persistent storage would do.
+func jsonRoundTrip[StateIdentifier comparable](t *testing.T, snapshot Snapshot[StateIdentifier]) Snapshot[StateIdentifier] {
+	data, err := json.Marshal(snapshot)
+	assert.NoError(t, err)
+	var restored Snapshot[StateIdentifier]
+	assert.NoError(t, json.Unmarshal(data, &restored))
+	return restored
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	var journal []string
+	sm := newConnSM(&journal)
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	snapshot, err := sm.Snapshot()
+	assert.NoError(t, err)
+	snapshot = jsonRoundTrip(t, snapshot)
+	assert.Equal(t, Snapshot[ConnSM]{State: Busy}, snapshot)
+
+	journal = nil
+	restored, err := newConnBuilder(&journal).Restore(snapshot, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.Equal(t, Busy, restored.State())
+	assert.Empty(t, journal)
+
+	assert.NoError(t, restored.ProcessEvent(connEvent("drop")))
+	assert.Equal(t, []string{"execute 3", "execute 1", "exit 3", "exit 1", "enter 0"}, journal)
+
+	journal = nil
+	restored, err = newConnBuilder(&journal).Restore(snapshot, RestoreRunOnEnter)
+	assert.NoError(t, err)
+	assert.Equal(t, Busy, restored.State())
+	assert.Equal(t, []string{"enter 1", "enter 3"}, journal)
+}
+
+func TestSnapshotRestoreRegions(t *testing.T) {
+	var journal []string
+	sm := newDeviceSM(&journal)
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("power")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("plug")))
+
+	snapshot, err := sm.Snapshot()
+	assert.NoError(t, err)
+	snapshot = jsonRoundTrip(t, snapshot)
+	assert.Equal(t, Snapshot[DeviceSM]{State: On, ActiveStates: []DeviceSM{Mains, Online}}, snapshot)
+
+	journal = nil
+	restored, err := newDeviceBuilder(&journal).Restore(snapshot, RestoreRunOnEnter)
+	assert.NoError(t, err)
+	assert.Equal(t, []DeviceSM{Mains, Online}, restored.ActiveStates())
+	assert.Equal(t, []string{"enter On", "enter Power", "enter Mains", "enter Connectivity", "enter Online"}, journal)
+}
+
+func TestSnapshotContext(t *testing.T) {
+	def, err := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetSMName("counter").
+		SetDefaultState(InProgress).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		SetFinalState(Stop).
+		SetContextCodec(counterCodec{limit: 5}).
+		BuildDefinition()
+	assert.NoError(t, err)
+
+	sm := def.NewInstance(&counterContext{limit: 5})
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 3}))
+	snapshot, err := sm.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, Snapshot[StartStopSM]{Name: "counter", State: InProgress, Context: []byte("3")}, snapshot)
+
+	restored, err := def.Restore(jsonRoundTrip(t, snapshot), nil, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.NoError(t, restored.ProcessEvent(counterEvent{delta: 2}))
+	assert.Equal(t, Stop, restored.State())
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	var journal []string
+	builder := newConnBuilder(&journal)
+
+	for _, snapshot := range []Snapshot[ConnSM]{
+		{Name: "other", State: Idle},
+		{State: Connected},
+		{State: ConnSM(42)},
+		{State: Connected, ActiveStates: []ConnSM{Idle, Busy}},
+	} {
+		_, err := builder.Restore(snapshot, RestoreSkipOnEnter)
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	}
+}
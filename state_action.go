@@ -1,5 +1,7 @@
 package gfsm
 
+import "context"
+
 // EventContext is an abstraction that represent any data that user passes to the current state for execution
 // in StateMachineHandler.ProcessEvent(...) call. The data will be forwarded as StateAction.Execute(...) argument.
 type EventContext interface{}
@@ -8,12 +10,73 @@ type EventContext interface{}
 // be forwarded as StateAction.OnEnter amd OnExit arguments.
 type StateMachineContext interface{}
 
-// StateAction is the interface which each state must implement.
-type StateAction[StateIdentifier comparable] interface {
+// TypedStateAction is the interface which each state must implement. Event is the type of data passed to
+// TypedStateMachineHandler.ProcessEvent(...), and SmContext is the type of the state machine context, which allows
+// to implement states without type assertions.
+type TypedStateAction[StateIdentifier comparable, Event any, SmContext any] interface {
 	// OnEnter will be called once on the state entering.
-	OnEnter(smCtx StateMachineContext)
+	OnEnter(smCtx SmContext)
 	// OnExit will be called once on the state exiting.
-	OnExit(smCtx StateMachineContext)
+	OnExit(smCtx SmContext)
 	// Execute is the call that state machine routes to the current state from StateMachineHandler.ProcessEvent(...)
-	Execute(smCtx StateMachineContext, eventCtx EventContext) StateIdentifier
+	// Returning the state's own identifier keeps the current state. For sub-states it also means that the event
+	// was not handled, and it will be passed to the parent state's Execute. States set up with
+	// StateMachineBuilder.SetSelfTransition(stateID, ExternalTransition) are exited and re-entered instead.
+	Execute(smCtx SmContext, eventCtx Event) StateIdentifier
+}
+
+// StateAction is the interface which each state must implement. It is the TypedStateAction accepting any
+// EventContext and StateMachineContext.
+type StateAction[StateIdentifier comparable] = TypedStateAction[StateIdentifier, EventContext, StateMachineContext]
+
+// TypedErrorAction is an optional interface for states which can fail to process an event. If a state implements
+// it in addition to TypedStateAction, TryExecute is called instead of Execute. A non-nil error stops the event
+// processing without any transition, and StateMachineHandler.ProcessEvent returns it wrapped into ExecuteError.
+type TypedErrorAction[StateIdentifier comparable, Event any, SmContext any] interface {
+	TryExecute(smCtx SmContext, eventCtx Event) (StateIdentifier, error)
+}
+
+// ErrorAction is the TypedErrorAction accepting any EventContext and StateMachineContext.
+type ErrorAction[StateIdentifier comparable] = TypedErrorAction[StateIdentifier, EventContext, StateMachineContext]
+
+// TypedContextAction is an optional interface for states which need the context.Context passed to
+// StateMachineHandler.StartCtx, ProcessEventCtx or Send, e.g. to stop a long-running processing on cancellation or to
+// attach tracing spans. If a state implements it in addition to TypedStateAction, its methods are called instead of
+// OnEnter, OnExit and Execute (or TypedErrorAction.TryExecute). Calls without a context, like Stop and Reset, pass
+// context.Background().
+type TypedContextAction[StateIdentifier comparable, Event any, SmContext any] interface {
+	OnEnterCtx(ctx context.Context, smCtx SmContext)
+	OnExitCtx(ctx context.Context, smCtx SmContext)
+	ExecuteCtx(ctx context.Context, smCtx SmContext, eventCtx Event) (StateIdentifier, error)
+}
+
+// ContextAction is the TypedContextAction accepting any EventContext and StateMachineContext.
+type ContextAction[StateIdentifier comparable] = TypedContextAction[StateIdentifier, EventContext, StateMachineContext]
+
+func (st *state[StateIdentifier, Event, SmContext]) onEnter(ctx context.Context, smCtx SmContext) {
+	if action, ok := st.action.(TypedContextAction[StateIdentifier, Event, SmContext]); ok {
+		action.OnEnterCtx(ctx, smCtx)
+		return
+	}
+	st.action.OnEnter(smCtx)
+}
+
+func (st *state[StateIdentifier, Event, SmContext]) onExit(ctx context.Context, smCtx SmContext) {
+	if action, ok := st.action.(TypedContextAction[StateIdentifier, Event, SmContext]); ok {
+		action.OnExitCtx(ctx, smCtx)
+		return
+	}
+	st.action.OnExit(smCtx)
+}
+
+// execute routes the event to ExecuteCtx if the state implements TypedContextAction, to TryExecute if it implements
+// TypedErrorAction, or to Execute otherwise.
+func (st *state[StateIdentifier, Event, SmContext]) execute(ctx context.Context, smCtx SmContext, eventCtx Event) (StateIdentifier, error) {
+	switch action := st.action.(type) {
+	case TypedContextAction[StateIdentifier, Event, SmContext]:
+		return action.ExecuteCtx(ctx, smCtx, eventCtx)
+	case TypedErrorAction[StateIdentifier, Event, SmContext]:
+		return action.TryExecute(smCtx, eventCtx)
+	}
+	return st.action.Execute(smCtx, eventCtx), nil
 }
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/astavonin/gfsm"
+)
+
+// MemoryLog is the gfsm.TypedEventLog keeping records in memory. It is safe for concurrent use.
+type MemoryLog[StateIdentifier comparable, Event any] struct {
+	mu      sync.Mutex
+	records map[string][]gfsm.TypedEventRecord[StateIdentifier, Event]
+}
+
+// NewMemoryLog creates an empty in-memory event log.
+func NewMemoryLog[StateIdentifier comparable, Event any]() *MemoryLog[StateIdentifier, Event] {
+	return &MemoryLog[StateIdentifier, Event]{records: map[string][]gfsm.TypedEventRecord[StateIdentifier, Event]{}}
+}
+
+func (m *MemoryLog[StateIdentifier, Event]) Append(id string, record gfsm.TypedEventRecord[StateIdentifier, Event]) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[id] = append(m.records[id], record)
+	return nil
+}
+
+func (m *MemoryLog[StateIdentifier, Event]) Records(id string) ([]gfsm.TypedEventRecord[StateIdentifier, Event], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]gfsm.TypedEventRecord[StateIdentifier, Event](nil), m.records[id]...), nil
+}
+
+// logRecord is a single line of the event log file.
+type logRecord[StateIdentifier comparable, Event any] struct {
+	ID     string                                        `json:"id"`
+	Record gfsm.TypedEventRecord[StateIdentifier, Event] `json:"record"`
+}
+
+// FileLog is the gfsm.TypedEventLog keeping records in an append-only local file, one JSON record per line. The file
+// is never compacted, as it is the audit trail of the instances. All the records are kept in memory as well, so
+// Records does not read the file.
+//
+// StateIdentifier and Event must be supported by encoding/json, and Event must decode into the same value it was
+// encoded from, so the replayed events are equal to the processed ones. For example, interface events of
+// gfsm.EventLog are decoded as maps and float64 numbers. FileLog is safe for concurrent use, but the file must not be
+// shared by several FileLog objects or processes.
+type FileLog[StateIdentifier comparable, Event any] struct {
+	mu   sync.Mutex
+	sync SyncPolicy
+	// f is nil after Close.
+	f *os.File
+
+	records map[string][]gfsm.TypedEventRecord[StateIdentifier, Event]
+}
+
+// OpenFileLog opens the event log at path, creating the file if it does not exist, and loads all the records. An
+// incomplete record at the end of the file, left by a crash during the write, is discarded. A complete record which
+// cannot be decoded fails the call with ErrInvalidRecord.
+func OpenFileLog[StateIdentifier comparable, Event any](path string, sync SyncPolicy) (*FileLog[StateIdentifier, Event], error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &FileLog[StateIdentifier, Event]{
+		sync:    sync,
+		f:       f,
+		records: map[string][]gfsm.TypedEventRecord[StateIdentifier, Event]{},
+	}
+	err = readRecords(f, func(rec logRecord[StateIdentifier, Event]) {
+		l.records[rec.ID] = append(l.records[rec.ID], rec.Record)
+	})
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot load event log %s: %w", path, err)
+	}
+	return l, nil
+}
+
+func (l *FileLog[StateIdentifier, Event]) Append(id string, record gfsm.TypedEventRecord[StateIdentifier, Event]) error {
+	line, err := json.Marshal(logRecord[StateIdentifier, Event]{ID: id, Record: record})
+	if err != nil {
+		return fmt.Errorf("cannot encode event %d of %q: %w", record.Index, id, err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if err := writeRecord(l.f, line); err != nil {
+		return err
+	}
+	if l.sync == SyncAlways {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+	}
+	l.records[id] = append(l.records[id], record)
+	return nil
+}
+
+func (l *FileLog[StateIdentifier, Event]) Records(id string) ([]gfsm.TypedEventRecord[StateIdentifier, Event], error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil, os.ErrClosed
+	}
+	return append([]gfsm.TypedEventRecord[StateIdentifier, Event](nil), l.records[id]...), nil
+}
+
+// Sync flushes all the written records to the disk.
+func (l *FileLog[StateIdentifier, Event]) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	return l.f.Sync()
+}
+
+// Close flushes the records to the disk and closes the file. The log cannot be used after Close.
+func (l *FileLog[StateIdentifier, Event]) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	err := l.f.Sync()
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f = nil
+	return err
+}
@@ -0,0 +1,305 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/astavonin/gfsm"
+)
+
+// ErrInvalidRecord is returned by OpenFile and OpenFileLog if a complete record of the file cannot be decoded. The
+// file is left untouched, as the records after the invalid one would be lost otherwise.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// SyncPolicy defines when File flushes the written records to the disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways calls fsync after each Save and Delete, so a returned nil error means the record survives a crash.
+	SyncAlways SyncPolicy = iota
+	// SyncNever leaves flushing to the operating system, records written after the last Sync, Compact or Close call
+	// can be lost on a crash, but not on a process restart.
+	SyncNever
+)
+
+// FileOptions configures File.
+type FileOptions struct {
+	Sync SyncPolicy
+	// CompactThreshold is the number of stale records, replaced or deleted, which triggers compaction on Save or
+	// Delete. Zero disables automatic compaction, Compact can be called explicitly.
+	CompactThreshold int
+}
+
+// fileRecord is a single line of the file.
+type fileRecord[StateIdentifier comparable] struct {
+	ID       string                         `json:"id"`
+	Snapshot gfsm.Snapshot[StateIdentifier] `json:"snapshot,omitzero"`
+	Deleted  bool                           `json:"deleted,omitempty"`
+}
+
+// File is the gfsm.Store keeping snapshots in an append-only local file, one JSON record per line. Each Save or
+// Delete appends a record, and the last record of an instance wins. All the live snapshots are kept in memory as
+// well, so Load does not read the file. Compaction rewrites the file with the live snapshots only.
+//
+// StateIdentifier must be supported by encoding/json. File is safe for concurrent use, but the file must not be
+// shared by several File objects or processes.
+type File[StateIdentifier comparable] struct {
+	mu   sync.Mutex
+	path string
+	opts FileOptions
+	// f is nil after Close.
+	f *os.File
+
+	snapshots map[string]gfsm.Snapshot[StateIdentifier]
+	// stale is the number of records in the file which do not define a live snapshot.
+	stale int
+}
+
+// OpenFile opens the store at path, creating the file if it does not exist, and loads all the snapshots. An incomplete
+// record at the end of the file, left by a crash during the write, is discarded. A complete record which cannot be
+// decoded fails the call with ErrInvalidRecord.
+func OpenFile[StateIdentifier comparable](path string, opts FileOptions) (*File[StateIdentifier], error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &File[StateIdentifier]{
+		path:      path,
+		opts:      opts,
+		f:         f,
+		snapshots: map[string]gfsm.Snapshot[StateIdentifier]{},
+	}
+	if err := s.load(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot load store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// load reads all the records and truncates the file after the last complete one.
+func (s *File[StateIdentifier]) load() error {
+	return readRecords(s.f, func(rec fileRecord[StateIdentifier]) {
+		s.apply(rec)
+	})
+}
+
+// readRecords passes each JSON record of f to apply, and truncates f after the last complete record, so a record
+// partially written before a crash is discarded. A complete record which cannot be decoded is reported as
+// ErrInvalidRecord, and f is not truncated then. f is positioned at its end after a successful call.
+func readRecords[Record any](f *os.File, apply func(rec Record)) error {
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// line is either empty or an incomplete record without the trailing newline
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%w at offset %d: %v", ErrInvalidRecord, valid, err)
+		}
+		valid += int64(len(line))
+		apply(rec)
+	}
+	if err := f.Truncate(valid); err != nil {
+		return err
+	}
+	_, err := f.Seek(valid, io.SeekStart)
+	return err
+}
+
+// recordFile is the part of *os.File records are appended with.
+type recordFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// writeRecord appends the record line to f. A failed write, like a short one on a full disk, is truncated back, as
+// the torn record would hide all the records appended after it from readRecords.
+func writeRecord(f recordFile, line []byte) error {
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		if truncErr := f.Truncate(offset); truncErr != nil {
+			return fmt.Errorf("%w, partial record is left: %v", err, truncErr)
+		}
+		if _, seekErr := f.Seek(offset, io.SeekStart); seekErr != nil {
+			return fmt.Errorf("%w, partial record is left: %v", err, seekErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// apply updates the live snapshots with the record.
+func (s *File[StateIdentifier]) apply(rec fileRecord[StateIdentifier]) {
+	if _, ok := s.snapshots[rec.ID]; ok {
+		s.stale++
+	}
+	if rec.Deleted {
+		delete(s.snapshots, rec.ID)
+		s.stale++
+		return
+	}
+	s.snapshots[rec.ID] = rec.Snapshot
+}
+
+func (s *File[StateIdentifier]) Save(id string, snapshot gfsm.Snapshot[StateIdentifier]) error {
+	return s.append(fileRecord[StateIdentifier]{ID: id, Snapshot: copySnapshot(snapshot)})
+}
+
+func (s *File[StateIdentifier]) Load(id string) (gfsm.Snapshot[StateIdentifier], error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return gfsm.Snapshot[StateIdentifier]{}, os.ErrClosed
+	}
+	snapshot, ok := s.snapshots[id]
+	if !ok {
+		return gfsm.Snapshot[StateIdentifier]{}, gfsm.ErrSnapshotNotFound
+	}
+	return copySnapshot(snapshot), nil
+}
+
+func (s *File[StateIdentifier]) Delete(id string) error {
+	s.mu.Lock()
+	_, ok := s.snapshots[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.append(fileRecord[StateIdentifier]{ID: id, Deleted: true})
+}
+
+// append writes the record and compacts the file if there are too many stale records.
+func (s *File[StateIdentifier]) append(rec fileRecord[StateIdentifier]) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("cannot encode snapshot of %q: %w", rec.ID, err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if err := writeRecord(s.f, line); err != nil {
+		return err
+	}
+	if s.opts.Sync == SyncAlways {
+		if err := s.f.Sync(); err != nil {
+			return err
+		}
+	}
+	s.apply(rec)
+	if s.opts.CompactThreshold > 0 && s.stale >= s.opts.CompactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+// Sync flushes all the written records to the disk.
+func (s *File[StateIdentifier]) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	return s.f.Sync()
+}
+
+// Compact rewrites the file keeping only the live snapshots. The new file is written aside and renamed over the old
+// one, so a crash during compaction leaves either the old or the new file.
+func (s *File[StateIdentifier]) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	return s.compact()
+}
+
+func (s *File[StateIdentifier]) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := s.writeLive(tmp); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot compact store %s: %w", s.path, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("cannot compact store %s: %w", s.path, err)
+	}
+	syncDir(filepath.Dir(s.path))
+
+	_ = s.f.Close()
+	s.f = tmp
+	s.stale = 0
+	return nil
+}
+
+// writeLive writes the live snapshots sorted by ID and flushes them to the disk.
+func (s *File[StateIdentifier]) writeLive(f *os.File) error {
+	ids := make([]string, 0, len(s.snapshots))
+	for id := range s.snapshots {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		line, err := json.Marshal(fileRecord[StateIdentifier]{ID: id, Snapshot: s.snapshots[id]})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// syncDir makes the rename durable. Not all platforms support directories sync, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
+
+// Close flushes the records to the disk and closes the file. The store cannot be used after Close.
+func (s *File[StateIdentifier]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	err := s.f.Sync()
+	if closeErr := s.f.Close(); err == nil {
+		err = closeErr
+	}
+	s.f = nil
+	return err
+}
@@ -0,0 +1,67 @@
+// Package store implements gfsm.Store for persisting state machines across process restarts without an external
+// database: Memory keeps snapshots in the process memory, and File keeps them in an append-only local file.
+// MemoryLog and FileLog are the gfsm.TypedEventLog implementations of the same kind.
+//
+//	fileStore, err := store.OpenFile[ConnState]("conn.log", store.FileOptions{Sync: store.SyncAlways})
+//	...
+//	sm := gfsm.NewBuilder[ConnState]().
+//		...
+//		SetStore(fileStore, gfsm.PersistWriteThrough).
+//		SetInstanceID("conn-1").
+//		Build()
+package store
+
+import (
+	"sync"
+
+	"github.com/astavonin/gfsm"
+)
+
+// Memory is the gfsm.Store keeping snapshots in memory. It is safe for concurrent use.
+type Memory[StateIdentifier comparable] struct {
+	mu        sync.Mutex
+	snapshots map[string]gfsm.Snapshot[StateIdentifier]
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory[StateIdentifier comparable]() *Memory[StateIdentifier] {
+	return &Memory[StateIdentifier]{snapshots: map[string]gfsm.Snapshot[StateIdentifier]{}}
+}
+
+func (m *Memory[StateIdentifier]) Save(id string, snapshot gfsm.Snapshot[StateIdentifier]) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[id] = copySnapshot(snapshot)
+	return nil
+}
+
+func (m *Memory[StateIdentifier]) Load(id string) (gfsm.Snapshot[StateIdentifier], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot, ok := m.snapshots[id]
+	if !ok {
+		return gfsm.Snapshot[StateIdentifier]{}, gfsm.ErrSnapshotNotFound
+	}
+	return copySnapshot(snapshot), nil
+}
+
+func (m *Memory[StateIdentifier]) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshots, id)
+	return nil
+}
+
+// copySnapshot keeps the stored snapshot independent of the caller's slices.
+func copySnapshot[StateIdentifier comparable](snapshot gfsm.Snapshot[StateIdentifier]) gfsm.Snapshot[StateIdentifier] {
+	if snapshot.ActiveStates != nil {
+		snapshot.ActiveStates = append([]StateIdentifier(nil), snapshot.ActiveStates...)
+	}
+	if snapshot.History != nil {
+		snapshot.History = append([]gfsm.HistoryEntry[StateIdentifier](nil), snapshot.History...)
+	}
+	if snapshot.Context != nil {
+		snapshot.Context = append([]byte(nil), snapshot.Context...)
+	}
+	return snapshot
+}
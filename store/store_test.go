@@ -0,0 +1,272 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/astavonin/gfsm"
+	"github.com/stretchr/testify/assert"
+)
+
+type DoorState int
+
+const (
+	Closed DoorState = iota
+	Opened
+	Locked
+)
+
+func newDoorSM(store gfsm.Store[DoorState], id string) gfsm.StateMachineHandler[DoorState] {
+	return gfsm.NewBuilder[DoorState]().
+		SetDefaultState(Closed).
+		RegisterState(Closed, &doorState{routes: map[string]DoorState{"open": Opened, "lock": Locked}}, []DoorState{Opened, Locked}).
+		RegisterState(Opened, &doorState{routes: map[string]DoorState{"close": Closed}}, []DoorState{Closed}).
+		RegisterState(Locked, &doorState{routes: map[string]DoorState{"unlock": Closed}}, []DoorState{Closed}).
+		SetStore(store, gfsm.PersistWriteThrough).
+		SetInstanceID(id).
+		Build()
+}
+
+type doorState struct {
+	routes map[string]DoorState
+}
+
+func (s *doorState) OnEnter(_ gfsm.StateMachineContext) {
+}
+
+func (s *doorState) OnExit(_ gfsm.StateMachineContext) {
+}
+
+func (s *doorState) Execute(_ gfsm.StateMachineContext, eventCtx gfsm.EventContext) DoorState {
+	return s.routes[eventCtx.(string)]
+}
+
+func TestMemory(t *testing.T) {
+	store := NewMemory[DoorState]()
+	sm := newDoorSM(store, "front")
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent("lock"))
+
+	snapshot, err := store.Load("front")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Locked}, snapshot)
+
+	assert.NoError(t, store.Delete("front"))
+	_, err = store.Load("front")
+	assert.ErrorIs(t, err, gfsm.ErrSnapshotNotFound)
+}
+
+func TestFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.log")
+	store, err := OpenFile[DoorState](path, FileOptions{Sync: SyncAlways})
+	assert.NoError(t, err)
+
+	front := newDoorSM(store, "front")
+	front.Start()
+	assert.NoError(t, front.ProcessEvent("open"))
+	back := newDoorSM(store, "back")
+	back.Start()
+	assert.NoError(t, back.ProcessEvent("lock"))
+	assert.NoError(t, store.Close())
+	assert.ErrorIs(t, store.Save("front", gfsm.Snapshot[DoorState]{}), os.ErrClosed)
+
+	store, err = OpenFile[DoorState](path, FileOptions{Sync: SyncAlways})
+	assert.NoError(t, err)
+	defer store.Close()
+	snapshot, err := store.Load("front")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Opened}, snapshot)
+	snapshot, err = store.Load("back")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Locked}, snapshot)
+}
+
+func TestFileIncompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.log")
+	data := `{"id":"front","snapshot":{"state":1}}` + "\n" + `{"id":"front","snap`
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+	store, err := OpenFile[DoorState](path, FileOptions{Sync: SyncNever})
+	assert.NoError(t, err)
+	snapshot, err := store.Load("front")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Opened}, snapshot)
+
+	// new records are appended after the last complete one
+	assert.NoError(t, store.Save("back", gfsm.Snapshot[DoorState]{State: Locked}))
+	assert.NoError(t, store.Close())
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"front","snapshot":{"state":1}}`+"\n"+`{"id":"back","snapshot":{"state":2}}`+"\n", string(content))
+}
+
+func TestFileInvalidRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.log")
+	data := `{"id":"front","snapshot":{"state":1}}` + "\n" +
+		`{"id":"side","snapshot":{"state":"Opened"}}` + "\n" +
+		`{"id":"back","snapshot":{"state":2}}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+	_, err := OpenFile[DoorState](path, FileOptions{Sync: SyncNever})
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	// the records after the invalid one are kept
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(content))
+
+	data = `{"id":"front","record":{"index":0,"event":"open","source":0,"target":1}}` + "\n" +
+		"garbage\n" +
+		`{"id":"front","record":{"index":1,"event":"close","source":1,"target":0}}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	_, err = OpenFileLog[DoorState, string](path, SyncNever)
+	assert.ErrorIs(t, err, ErrInvalidRecord)
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(content))
+}
+
+// tornFile writes only a half of each record and fails, the same way a write to a full disk does.
+type tornFile struct {
+	*os.File
+}
+
+func (f tornFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, io.ErrShortWrite
+}
+
+func TestFileTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.log")
+	store, err := OpenFile[DoorState](path, FileOptions{Sync: SyncAlways})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save("front", gfsm.Snapshot[DoorState]{State: Opened}))
+
+	err = writeRecord(tornFile{store.f}, []byte(`{"id":"side","snapshot":{"state":2}}`+"\n"))
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+	assert.NoError(t, store.Save("back", gfsm.Snapshot[DoorState]{State: Locked}))
+	assert.NoError(t, store.Close())
+
+	// the record appended after the failed one survives reopening
+	store, err = OpenFile[DoorState](path, FileOptions{Sync: SyncAlways})
+	assert.NoError(t, err)
+	defer store.Close()
+	snapshot, err := store.Load("back")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Locked}, snapshot)
+	_, err = store.Load("side")
+	assert.ErrorIs(t, err, gfsm.ErrSnapshotNotFound)
+}
+
+func TestFileCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.log")
+	store, err := OpenFile[DoorState](path, FileOptions{Sync: SyncNever, CompactThreshold: 5})
+	assert.NoError(t, err)
+
+	sm := newDoorSM(store, "front")
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent("open"))
+	assert.NoError(t, sm.ProcessEvent("close"))
+	assert.NoError(t, store.Save("back", gfsm.Snapshot[DoorState]{State: Locked}))
+	assert.NoError(t, store.Delete("back"))
+	assert.Equal(t, 5, countRecords(t, path))
+
+	// the fifth stale record triggers compaction
+	assert.NoError(t, sm.ProcessEvent("lock"))
+	assert.Equal(t, 1, countRecords(t, path))
+
+	assert.NoError(t, sm.ProcessEvent("unlock"))
+	assert.NoError(t, store.Compact())
+	assert.Equal(t, 1, countRecords(t, path))
+	assert.NoError(t, store.Close())
+
+	store, err = OpenFile[DoorState](path, FileOptions{})
+	assert.NoError(t, err)
+	defer store.Close()
+	snapshot, err := store.Load("front")
+	assert.NoError(t, err)
+	assert.Equal(t, gfsm.Snapshot[DoorState]{State: Closed}, snapshot)
+	_, err = store.Load("back")
+	assert.ErrorIs(t, err, gfsm.ErrSnapshotNotFound)
+}
+
+func countRecords(t *testing.T, path string) int {
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return strings.Count(string(content), "\n")
+}
+
+type doorEvent string
+
+type typedDoorState struct {
+	routes map[doorEvent]DoorState
+}
+
+func (s *typedDoorState) OnEnter(_ *int) {
+}
+
+func (s *typedDoorState) OnExit(_ *int) {
+}
+
+func (s *typedDoorState) Execute(openings *int, eventCtx doorEvent) DoorState {
+	target, ok := s.routes[eventCtx]
+	if ok && target == Opened {
+		*openings++
+	}
+	return target
+}
+
+func newDoorBuilder(log gfsm.TypedEventLog[DoorState, doorEvent]) gfsm.TypedStateMachineBuilder[DoorState, doorEvent, *int] {
+	return gfsm.NewTypedBuilder[DoorState, doorEvent, *int]().
+		SetDefaultState(Closed).
+		RegisterState(Closed, &typedDoorState{routes: map[doorEvent]DoorState{"open": Opened, "lock": Locked}}, []DoorState{Opened, Locked}).
+		RegisterState(Opened, &typedDoorState{routes: map[doorEvent]DoorState{"close": Closed}}, []DoorState{Closed}).
+		RegisterState(Locked, &typedDoorState{routes: map[doorEvent]DoorState{"unlock": Closed}}, []DoorState{Closed}).
+		SetEventLog(log).
+		SetInstanceID("front")
+}
+
+func TestFileLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doors.events")
+	log, err := OpenFileLog[DoorState, doorEvent](path, SyncAlways)
+	assert.NoError(t, err)
+
+	openings := 0
+	sm := newDoorBuilder(log).SetSmContext(&openings).Build()
+	sm.Start()
+	for _, ev := range []doorEvent{"open", "close", "lock", "unlock", "open"} {
+		assert.NoError(t, sm.ProcessEvent(ev))
+	}
+	assert.NoError(t, log.Close())
+
+	log, err = OpenFileLog[DoorState, doorEvent](path, SyncAlways)
+	assert.NoError(t, err)
+	defer log.Close()
+	records, err := log.Records("front")
+	assert.NoError(t, err)
+	assert.Len(t, records, 5)
+	assert.Equal(t, gfsm.TypedEventRecord[DoorState, doorEvent]{Index: 2, Event: "lock", Source: Closed, Target: Locked}, records[2])
+
+	replayedOpenings := 0
+	replayed, err := newDoorBuilder(log).SetSmContext(&replayedOpenings).Replay()
+	assert.NoError(t, err)
+	assert.Equal(t, Opened, replayed.State())
+	assert.Equal(t, 2, replayedOpenings)
+}
+
+func TestMemoryLog(t *testing.T) {
+	log := NewMemoryLog[DoorState, doorEvent]()
+	openings := 0
+	sm := newDoorBuilder(log).SetSmContext(&openings).Build()
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent("lock"))
+
+	records, err := log.Records("front")
+	assert.NoError(t, err)
+	assert.Equal(t, []gfsm.TypedEventRecord[DoorState, doorEvent]{{Event: "lock", Source: Closed, Target: Locked}}, records)
+	records, err = log.Records("back")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
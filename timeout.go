@@ -0,0 +1,80 @@
+package gfsm
+
+import (
+	"context"
+	"time"
+)
+
+// stateTimeout is the timeout of a state set with StateMachineBuilder.SetStateTimeout or SetStateTimeoutEvent.
+type stateTimeout[StateIdentifier comparable, Event any] struct {
+	duration time.Duration
+	// targetID is the state switched to on timeout, valid only if hasEvent is not set.
+	targetID StateIdentifier
+	// event is processed on timeout, valid only if hasEvent is set.
+	event    Event
+	hasEvent bool
+}
+
+// stateTimer is the armed timeout of an active state. Each entering of the state arms a new one, so the timer
+// firing after the state is exited can recognize itself as stale.
+type stateTimer struct {
+	timer Timer
+}
+
+// startTimer arms the timeout of the entered state, if it has one.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) startTimer(stateID StateIdentifier) {
+	st := s.states[stateID]
+	if !st.hasTimeout {
+		return
+	}
+	x := s.extra()
+	if x.timers == nil {
+		x.timers = map[StateIdentifier]*stateTimer{}
+	}
+	t := &stateTimer{}
+	x.timers[stateID] = t
+	t.timer = s.clock.AfterFunc(st.timeout.duration, func() {
+		s.fireTimeout(stateID, t)
+	})
+}
+
+// stopTimer cancels the timeout of the exited state, if it has one.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) stopTimer(stateID StateIdentifier) {
+	x := s.extras.Load()
+	if x == nil {
+		return
+	}
+	if t, ok := x.timers[stateID]; ok {
+		t.timer.Stop()
+		delete(x.timers, stateID)
+	}
+}
+
+// fireTimeout is called by the clock, when the timeout of stateID expires. The timeout is ignored if the state was
+// exited while the timer was waiting for the lock. Errors are reported to TypedListener.TimeoutFailed.
+func (s *stateMachine[StateIdentifier, Event, SmContext]) fireTimeout(stateID StateIdentifier, t *stateTimer) {
+	s.lockEvents()
+	defer s.unlockEvents()
+	// the extras are allocated when the timer is armed
+	x := s.extras.Load()
+	if s.status != StatusRunning || s.finished || x.timers[stateID] != t {
+		return
+	}
+	delete(x.timers, stateID)
+
+	ctx := context.Background()
+	timeout := s.states[stateID].timeout
+	var err error
+	if timeout.hasEvent {
+		err = s.processLogged(ctx, timeout.event)
+	} else {
+		err = s.applyLogged(ctx, RecordTimeout, stateID, timeout.event)
+	}
+	if err != nil {
+		// a panicking listener is handled the same way as in other callbacks
+		_ = s.protect(ctx, func() error {
+			s.notify(ctx, hookTimeoutFailed, stateID, s.currentStateID, timeout.event, err)
+			return nil
+		})
+	}
+}
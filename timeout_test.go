@@ -0,0 +1,155 @@
+package gfsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateTimeout(t *testing.T) {
+	var journal []string
+	clock := NewFakeClock(time.Unix(0, 0))
+	sm := newConnBuilder(&journal).
+		SetStateTimeout(Busy, 5*time.Second, Idle).
+		SetClock(clock).
+		Build()
+	sm.Start()
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, 1, clock.PendingTimers())
+
+	clock.Advance(4 * time.Second)
+	assert.Equal(t, Busy, sm.State())
+
+	journal = nil
+	clock.Advance(time.Second)
+	assert.Equal(t, Idle, sm.State())
+	assert.Equal(t, []string{"exit 3", "enter 2"}, journal)
+	assert.Equal(t, 0, clock.PendingTimers())
+
+	// each entering arms a new timer
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	clock.Advance(3 * time.Second)
+	assert.NoError(t, sm.ProcessEvent(connEvent("done")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, Busy, sm.State())
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, Idle, sm.State())
+}
+
+func TestStateTimeoutCancelledOnExit(t *testing.T) {
+	var journal []string
+	clock := NewFakeClock(time.Unix(0, 0))
+	sm := newConnBuilder(&journal).
+		SetStateTimeout(Connected, time.Minute, Disconnected).
+		SetClock(clock).
+		Build()
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	// the timeout of the composite state stays armed while its sub-states change
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, 1, clock.PendingTimers())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("drop")))
+	assert.Equal(t, 0, clock.PendingTimers())
+
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, 1, clock.PendingTimers())
+	sm.Stop()
+	assert.Equal(t, 0, clock.PendingTimers())
+
+	journal = nil
+	clock.Advance(time.Hour)
+	assert.Empty(t, journal)
+}
+
+func TestStateTimeoutEvent(t *testing.T) {
+	var journal []string
+	clock := NewFakeClock(time.Unix(0, 0))
+	log := newSliceLog[ConnSM, EventContext]()
+	sm := newConnBuilder(&journal).
+		SetStateTimeoutEvent(Busy, time.Second, connEvent("drain")).
+		SetEventLog(log).
+		SetClock(clock).
+		Build()
+	sm.Start()
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	journal = nil
+	clock.Advance(time.Second)
+	assert.Equal(t, Draining, sm.State())
+	assert.Equal(t, []string{"execute 3", "exit 3", "enter 4"}, journal)
+	// timeout events are logged, so they are replayed as well
+	assert.Equal(t, EventRecord[ConnSM]{Index: 2, Event: connEvent("drain"), Source: Busy, Target: Draining},
+		log.records[""][2])
+}
+
+func TestStateTimeoutFailed(t *testing.T) {
+	var journal []string
+	var failed []TransitionInfo[ConnSM]
+	clock := NewFakeClock(time.Unix(0, 0))
+	crash := func(_ StateMachineContext, _ EventContext) {
+		panic("bad action")
+	}
+	sm := newConnBuilder(&journal).
+		SetStateTimeout(Busy, time.Second, Idle).
+		SetTransitionAction(Busy, Idle, crash).
+		SetPanicRecovery(true).
+		SetClock(clock).
+		AddListener(Listener[ConnSM]{
+			TimeoutFailed: func(_ context.Context, info TransitionInfo[ConnSM]) {
+				failed = append(failed, info)
+			},
+		}).
+		Build()
+	sm.Start()
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	// the timeout has no caller, so its error is reported to the listeners
+	clock.Advance(time.Second)
+	assert.Equal(t, Busy, sm.State())
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, Busy, failed[0].Source)
+		assert.Equal(t, Busy, failed[0].Target)
+		var panicErr *PanicError[ConnSM]
+		assert.ErrorAs(t, failed[0].Err, &panicErr)
+		assert.Equal(t, PhaseTransitionAction, panicErr.Phase)
+	}
+}
+
+func TestStateTimeoutRestore(t *testing.T) {
+	var journal []string
+	clock := NewFakeClock(time.Unix(0, 0))
+	restored, err := newConnBuilder(&journal).
+		SetStateTimeout(Busy, 5*time.Second, Idle).
+		SetClock(clock).
+		Restore(Snapshot[ConnSM]{State: Busy}, RestoreSkipOnEnter)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, clock.PendingTimers())
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, Idle, restored.State())
+}
+
+func TestStateTimeoutSystemClock(t *testing.T) {
+	var journal []string
+	sm := newConnBuilder(&journal).
+		SetStateTimeout(Busy, 10*time.Millisecond, Idle).
+		Build()
+	sm.Start()
+	defer sm.Stop()
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+
+	assert.Eventually(t, func() bool {
+		return sm.State() == Idle
+	}, time.Second, time.Millisecond)
+}
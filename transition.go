@@ -0,0 +1,70 @@
+package gfsm
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypedGuard is the condition of a declarative transition registered with StateMachineBuilder.AddTransition. The
+// transition can be taken only if the guard returns true.
+type TypedGuard[Event any, SmContext any] func(smCtx SmContext, eventCtx Event) bool
+
+// Guard is the TypedGuard for any EventContext and StateMachineContext.
+type Guard = TypedGuard[EventContext, StateMachineContext]
+
+// TypedTransitionAction is executed when a declarative transition is taken, after the source state is exited and
+// before the target state is entered.
+type TypedTransitionAction[Event any, SmContext any] func(smCtx SmContext, eventCtx Event)
+
+// TransitionAction is the TypedTransitionAction for any EventContext and StateMachineContext.
+type TransitionAction = TypedTransitionAction[EventContext, StateMachineContext]
+
+// SelfTransitionKind defines whether a transition of a state to itself calls OnExit and OnEnter of the state.
+type SelfTransitionKind int
+
+const (
+	// InternalTransition keeps the state active without calling any callbacks.
+	InternalTransition SelfTransitionKind = iota
+	// ExternalTransition exits and re-enters the state, calling OnExit and OnEnter of the state and its active
+	// sub-states. Timeouts of the re-entered states are armed again.
+	ExternalTransition
+)
+
+func (k SelfTransitionKind) String() string {
+	switch k {
+	case InternalTransition:
+		return "InternalTransition"
+	case ExternalTransition:
+		return "ExternalTransition"
+	default:
+		return fmt.Sprintf("SelfTransitionKind(%d)", int(k))
+	}
+}
+
+// guardedTransition is a transition declared on the builder. It is evaluated before the state's StateAction.Execute.
+type guardedTransition[StateIdentifier comparable, Event any, SmContext any] struct {
+	// eventType is the dynamic type of events the transition reacts on, nil matches any event.
+	eventType reflect.Type
+	guard     TypedGuard[Event, SmContext]
+	targetID  StateIdentifier
+	action    TypedTransitionAction[Event, SmContext]
+	// internal transitions execute the action without leaving the state, targetID is not used.
+	internal bool
+}
+
+func (t *guardedTransition[StateIdentifier, Event, SmContext]) matches(smCtx SmContext, eventCtx Event) bool {
+	if t.eventType != nil && t.eventType != reflect.TypeOf(eventCtx) {
+		return false
+	}
+	return t.guard == nil || t.guard(smCtx, eventCtx)
+}
+
+// findTransition returns the first declarative transition of the state, in registration order, matching the event.
+func (st *state[StateIdentifier, Event, SmContext]) findTransition(smCtx SmContext, eventCtx Event) *guardedTransition[StateIdentifier, Event, SmContext] {
+	for i := range st.guarded {
+		if st.guarded[i].matches(smCtx, eventCtx) {
+			return &st.guarded[i]
+		}
+	}
+	return nil
+}
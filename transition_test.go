@@ -0,0 +1,74 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dialEvent struct {
+	accepted bool
+}
+
+type connCounter struct {
+	dials int
+}
+
+func newGuardedConnSM(journal *[]string) StateMachineHandler[ConnSM] {
+	state := func(id ConnSM, routes map[connEvent]ConnSM) *recordingState[ConnSM] {
+		return &recordingState[ConnSM]{id: id, journal: journal, routes: routes}
+	}
+	accepted := func(_ StateMachineContext, eventCtx EventContext) bool {
+		return eventCtx.(dialEvent).accepted
+	}
+	countDial := func(smCtx StateMachineContext, _ EventContext) {
+		smCtx.(*connCounter).dials++
+		*journal = append(*journal, "count dial")
+	}
+	return NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		SetSmContext(&connCounter{}).
+		RegisterState(Disconnected, state(Disconnected, map[connEvent]ConnSM{"connect": Connected}), []ConnSM{Connected}).
+		RegisterState(Connected, state(Connected, nil), nil).
+		AddTransition(Disconnected, dialEvent{}, accepted, Connected, countDial).
+		AddTransition(Connected, connEvent("drop"), nil, Disconnected, nil).
+		Build()
+}
+
+func TestGuardedTransitions(t *testing.T) {
+	var journal []string
+	sm := newGuardedConnSM(&journal)
+	sm.Start()
+
+	// guard rejects the event, Execute is used as a fallback and keeps the state
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(dialEvent{accepted: false}))
+	assert.Equal(t, Disconnected, sm.State())
+	assert.Equal(t, []string{"execute 0"}, journal)
+
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(dialEvent{accepted: true}))
+	assert.Equal(t, Connected, sm.State())
+	assert.Equal(t, []string{"exit 0", "count dial", "enter 1"}, journal)
+	assert.Equal(t, 1, sm.(*stateMachine[ConnSM, EventContext, StateMachineContext]).smCtx.(*connCounter).dials)
+
+	// transitions without guard match by the event type only
+	journal = nil
+	assert.NoError(t, sm.ProcessEvent(connEvent("anything")))
+	assert.Equal(t, Disconnected, sm.State())
+	assert.Equal(t, []string{"exit 1", "enter 0"}, journal)
+
+	// Execute routes are still available
+	assert.NoError(t, sm.ProcessEvent(connEvent("connect")))
+	assert.Equal(t, Connected, sm.State())
+}
+
+func TestGuardedTransitionUnknownSource(t *testing.T) {
+	assert.Panics(t, func() {
+		NewBuilder[ConnSM]().
+			SetDefaultState(Disconnected).
+			RegisterState(Disconnected, &recordingState[ConnSM]{}, nil).
+			AddTransition(Connected, nil, nil, Disconnected, nil).
+			Build()
+	})
+}
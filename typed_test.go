@@ -0,0 +1,82 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type counterEvent struct {
+	delta int
+}
+
+type counterContext struct {
+	value int
+	limit int
+}
+
+type countingState struct{}
+
+func (s *countingState) OnEnter(smCtx *counterContext) {
+	smCtx.value = 0
+}
+
+func (s *countingState) OnExit(_ *counterContext) {
+}
+
+func (s *countingState) Execute(smCtx *counterContext, eventCtx counterEvent) StartStopSM {
+	smCtx.value += eventCtx.delta
+	if smCtx.value >= smCtx.limit {
+		return Stop
+	}
+	return InProgress
+}
+
+type stoppedState struct{}
+
+func (s *stoppedState) OnEnter(_ *counterContext) {
+}
+
+func (s *stoppedState) OnExit(_ *counterContext) {
+}
+
+func (s *stoppedState) Execute(_ *counterContext, _ counterEvent) StartStopSM {
+	return Stop
+}
+
+func TestTypedStateMachine(t *testing.T) {
+	smCtx := &counterContext{limit: 3}
+	negative := func(_ *counterContext, eventCtx counterEvent) bool {
+		return eventCtx.delta < 0
+	}
+	sm := NewTypedBuilder[StartStopSM, counterEvent, *counterContext]().
+		SetDefaultState(InProgress).
+		SetSmContext(smCtx).
+		RegisterState(InProgress, &countingState{}, []StartStopSM{Stop}).
+		RegisterState(Stop, &stoppedState{}, nil).
+		AddTransition(InProgress, counterEvent{}, negative, Stop, nil).
+		Build()
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 2}))
+	assert.Equal(t, InProgress, sm.State())
+	assert.Equal(t, 2, smCtx.value)
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: 1}))
+	assert.Equal(t, Stop, sm.State())
+
+	sm.Reset()
+	assert.Equal(t, 0, smCtx.value)
+	assert.NoError(t, sm.ProcessEvent(counterEvent{delta: -1}))
+	assert.Equal(t, Stop, sm.State())
+	assert.Equal(t, 0, smCtx.value)
+	sm.Stop()
+}
+
+func TestUntypedAliases(t *testing.T) {
+	var action StateAction[StartStopSM] = &StartState{}
+	var typed TypedStateAction[StartStopSM, EventContext, StateMachineContext] = action
+	assert.NotNil(t, typed)
+
+	var builder StateMachineBuilder[StartStopSM] = NewTypedBuilder[StartStopSM, EventContext, StateMachineContext]()
+	assert.NotNil(t, builder)
+}
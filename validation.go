@@ -0,0 +1,248 @@
+package gfsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoStates is reported for state machines without any registered state.
+	ErrNoStates = fmt.Errorf("no states registered")
+	// ErrNoDefaultState is reported if StateMachineBuilder.SetDefaultState was not called.
+	ErrNoDefaultState = fmt.Errorf("default state is not set")
+	// ErrDuplicateState is reported if a state is registered more than once.
+	ErrDuplicateState = fmt.Errorf("state is already registered")
+	// ErrStateNotRegistered is reported for references to states which were not registered: the default state,
+	// transition sources and targets, parents, default sub-states and final states.
+	ErrStateNotRegistered = fmt.Errorf("state is not registered")
+	// ErrInvalidHierarchy is reported for inconsistent states hierarchy, like cyclic parent chains, composite states
+	// without default sub-state or parallel states without regions.
+	ErrInvalidHierarchy = fmt.Errorf("invalid states hierarchy")
+	// ErrUnreachableState is reported for states which cannot be reached from the default state.
+	ErrUnreachableState = fmt.Errorf("state is unreachable")
+	// ErrInvalidPseudoState is reported for choices and junctions without branches, choices without a branch
+	// without guard or leading to other choices and junctions, and junctions leading to themselves.
+	ErrInvalidPseudoState = fmt.Errorf("invalid pseudo-state")
+	// ErrInvalidTransition is reported for transitions to choice and junction pseudo-states from states which do not
+	// allow all their branch targets, the same way as for states targeted directly. ChangeState and ProcessEvent
+	// return it for such transitions as well, and it wraps ErrNoValidTransition then.
+	ErrInvalidTransition = fmt.Errorf("branch target is not an allowed transition: %w", ErrNoValidTransition)
+	// ErrDeadEndState is reported for states without any transition to another state, which are not marked final.
+	ErrDeadEndState = fmt.Errorf("state has no way out and is not final")
+)
+
+// DefinitionError describes a single problem of a state machine definition.
+type DefinitionError[StateIdentifier comparable] struct {
+	// State is the state the problem relates to. It is not set for ErrNoStates and ErrNoDefaultState.
+	State StateIdentifier
+	// Err is one of the Err* variables classifying the problem.
+	Err error
+
+	msg string
+}
+
+func newDefinitionError[StateIdentifier comparable](stateID StateIdentifier, err error, format string, args ...any) *DefinitionError[StateIdentifier] {
+	return &DefinitionError[StateIdentifier]{State: stateID, Err: err, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *DefinitionError[StateIdentifier]) Error() string {
+	return e.msg
+}
+
+func (e *DefinitionError[StateIdentifier]) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned by StateMachineBuilder.Validate and BuildE. It contains every problem found in the
+// state machine definition, and matches each of them with errors.Is and errors.As.
+type ValidationError[StateIdentifier comparable] struct {
+	Errors []*DefinitionError[StateIdentifier]
+}
+
+func (e *ValidationError[StateIdentifier]) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid state machine: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError[StateIdentifier]) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// validationError returns nil if there are no problems, or ValidationError otherwise.
+func validationError[StateIdentifier comparable](problems []*DefinitionError[StateIdentifier]) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError[StateIdentifier]{Errors: problems}
+}
+
+// checkDefinition verifies the linked state machine: the default state and all transition targets must be
+// registered, states must allow all branch targets of the choices and junctions they switch to, each state must be
+// reachable from the default state, and each leaf state must either have a way out
+// or be final.
+func (s *stateMachineBuilder[StateIdentifier, Event, SmContext]) checkDefinition() []*DefinitionError[StateIdentifier] {
+	var problems []*DefinitionError[StateIdentifier]
+	if s.hasDefaultState {
+		if _, ok := s.def.states[s.def.defaultStateID]; !ok {
+			problems = append(problems, newDefinitionError(s.def.defaultStateID, ErrStateNotRegistered,
+				"default state %v is not registered", s.def.defaultStateID))
+		}
+	}
+	if s.def.hasErrorState {
+		if _, ok := s.def.states[s.def.errorStateID]; !ok {
+			problems = append(problems, newDefinitionError(s.def.errorStateID, ErrStateNotRegistered,
+				"error state %v is not registered", s.def.errorStateID))
+		}
+	}
+	for _, stateID := range s.finalOrder {
+		if _, ok := s.def.states[stateID]; !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrStateNotRegistered,
+				"final state %v is not registered", stateID))
+		}
+	}
+	for _, stateID := range s.registered {
+		for _, targetID := range s.transitionOrder[stateID] {
+			if !s.def.isTarget(targetID) {
+				problems = append(problems, newDefinitionError(targetID, ErrStateNotRegistered,
+					"transition target %v of %v is not registered", targetID, stateID))
+			}
+		}
+	}
+	for _, pseudoID := range s.def.pseudoOrder {
+		for _, b := range s.def.pseudo[pseudoID].branches {
+			if !s.def.isTarget(b.targetID) {
+				problems = append(problems, newDefinitionError(b.targetID, ErrStateNotRegistered,
+					"branch target %v of %v is not registered", b.targetID, pseudoID))
+			}
+		}
+	}
+	for _, stateID := range s.registered {
+		checked := map[StateIdentifier]struct{}{}
+		for _, targetID := range s.transitionOrder[stateID] {
+			if _, ok := checked[targetID]; ok {
+				continue
+			}
+			checked[targetID] = struct{}{}
+			if pseudo, ok := s.def.pseudo[targetID]; !ok || !pseudo.isBranching() {
+				continue
+			}
+			for _, branchID := range s.def.branchTargets(targetID, nil) {
+				if !s.def.canSwitch(stateID, branchID) {
+					problems = append(problems, newDefinitionError(stateID, ErrInvalidTransition,
+						"branch target %v of %v is not an allowed transition of %v", branchID, targetID, stateID))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		// reachability makes no sense for broken transitions
+		return problems
+	}
+
+	reachable := s.def.reachableStates()
+	for _, stateID := range s.registered {
+		if _, ok := reachable[stateID]; !ok {
+			problems = append(problems, newDefinitionError(stateID, ErrUnreachableState,
+				"state %v is unreachable from the default state %v", stateID, s.def.defaultStateID))
+		}
+	}
+	for _, stateID := range s.registered {
+		st := s.def.states[stateID]
+		if st.composite || st.final || s.def.hasWayOut(stateID) {
+			continue
+		}
+		problems = append(problems, newDefinitionError(stateID, ErrDeadEndState,
+			"state %v has no way out and is not final", stateID))
+	}
+	return problems
+}
+
+// reachableStates returns all states which can become active starting from the default state. An entered state is
+// active together with its ancestors, its default sub-state and, for parallel states, all its regions. The ancestors
+// are not entered, so their default sub-states are reached only by a transition targeting the composite state.
+func (d *definition[StateIdentifier, Event, SmContext]) reachableStates() map[StateIdentifier]struct{} {
+	reached := map[StateIdentifier]struct{}{}
+	entered := map[StateIdentifier]struct{}{}
+	queue := []StateIdentifier{}
+	var visit func(stateID StateIdentifier)
+	visit = func(stateID StateIdentifier) {
+		if _, ok := entered[stateID]; ok {
+			return
+		}
+		entered[stateID] = struct{}{}
+		if pseudo, ok := d.pseudo[stateID]; ok {
+			reached[stateID] = struct{}{}
+			if !pseudo.isBranching() {
+				visit(pseudo.parent)
+				return
+			}
+			for _, b := range pseudo.branches {
+				visit(b.targetID)
+			}
+			return
+		}
+		if _, ok := d.states[stateID]; !ok {
+			return
+		}
+		reached[stateID] = struct{}{}
+		queue = append(queue, stateID)
+	}
+
+	visit(d.defaultStateID)
+	if d.hasErrorState {
+		// a panic can happen anywhere
+		visit(d.errorStateID)
+	}
+	for len(queue) > 0 {
+		stateID := queue[0]
+		st := d.states[stateID]
+		queue = queue[1:]
+		for ancestorID, ok := d.parentOf(stateID); ok; ancestorID, ok = d.parentOf(ancestorID) {
+			// the transitions of the active ancestors are valid from the sub-state
+			reached[ancestorID] = struct{}{}
+			for targetID := range d.states[ancestorID].transitions {
+				visit(targetID)
+			}
+		}
+		switch {
+		case st.parallel:
+			for _, regionID := range st.regions {
+				visit(regionID)
+			}
+		case st.composite:
+			visit(st.defaultSubState)
+		}
+		for targetID := range st.transitions {
+			visit(targetID)
+		}
+	}
+	return reached
+}
+
+// isTarget reports whether targetID is a registered state or pseudo-state.
+func (d *definition[StateIdentifier, Event, SmContext]) isTarget(targetID StateIdentifier) bool {
+	if _, ok := d.states[targetID]; ok {
+		return true
+	}
+	_, ok := d.pseudo[targetID]
+	return ok
+}
+
+// hasWayOut reports whether stateID or any of its ancestors has a transition to another state.
+func (d *definition[StateIdentifier, Event, SmContext]) hasWayOut(stateID StateIdentifier) bool {
+	for ancestorID, ok := stateID, true; ok; ancestorID, ok = d.parentOf(ancestorID) {
+		for targetID := range d.states[ancestorID].transitions {
+			if targetID != stateID {
+				return true
+			}
+		}
+	}
+	return false
+}
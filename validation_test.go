@@ -0,0 +1,112 @@
+package gfsm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// definitionErrors returns all problems reported by err, which must be ValidationError.
+func definitionErrors(t *testing.T, err error) []DefinitionError[ConnSM] {
+	var validationErr *ValidationError[ConnSM]
+	if !assert.ErrorAs(t, err, &validationErr) {
+		return nil
+	}
+	var problems []DefinitionError[ConnSM]
+	for _, problem := range validationErr.Errors {
+		problems = append(problems, DefinitionError[ConnSM]{State: problem.State, Err: problem.Err})
+	}
+	return problems
+}
+
+func TestValidateEmptyDefinition(t *testing.T) {
+	err := NewBuilder[ConnSM]().Validate()
+	assert.ErrorIs(t, err, ErrNoStates)
+	assert.ErrorIs(t, err, ErrNoDefaultState)
+
+	sm, err := NewBuilder[ConnSM]().BuildE()
+	assert.Nil(t, sm)
+	assert.ErrorIs(t, err, ErrNoDefaultState)
+}
+
+func TestValidateDefinitionProblems(t *testing.T) {
+	noop := &recordingState[ConnSM]{}
+
+	err := NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		RegisterState(Idle, noop, []ConnSM{Busy}).
+		RegisterState(Busy, noop, []ConnSM{Idle, Draining}).
+		Validate()
+	assert.Equal(t, []DefinitionError[ConnSM]{
+		{State: Disconnected, Err: ErrStateNotRegistered},
+		{State: Draining, Err: ErrStateNotRegistered},
+	}, definitionErrors(t, err))
+
+	err = NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		RegisterState(Disconnected, noop, []ConnSM{Connected}).
+		RegisterState(Connected, noop, nil).
+		RegisterSubState(Connected, Idle, noop, []ConnSM{Busy}).
+		RegisterSubState(Connected, Busy, noop, nil).
+		SetDefaultSubState(Connected, Idle).
+		RegisterState(Draining, noop, []ConnSM{Disconnected}).
+		Validate()
+	assert.Equal(t, []DefinitionError[ConnSM]{
+		{State: Draining, Err: ErrUnreachableState},
+		{State: Busy, Err: ErrDeadEndState},
+	}, definitionErrors(t, err))
+	assert.EqualError(t, err, "invalid state machine: state 4 is unreachable from the default state 0; "+
+		"state 3 has no way out and is not final")
+
+	err = NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, noop, []ConnSM{Busy}).
+		RegisterState(Busy, noop, nil).
+		RegisterState(Busy, noop, nil).
+		SetFinalState(Busy).
+		Validate()
+	assert.Equal(t, []DefinitionError[ConnSM]{
+		{State: Busy, Err: ErrDuplicateState},
+	}, definitionErrors(t, err))
+}
+
+func TestValidateUnreachableDefaultSubState(t *testing.T) {
+	noop := &recordingState[ConnSM]{}
+
+	// Connected is active together with Busy, but Idle is entered only by a transition to Connected
+	err := NewBuilder[ConnSM]().
+		SetDefaultState(Disconnected).
+		RegisterState(Disconnected, noop, []ConnSM{Busy}).
+		RegisterState(Connected, noop, []ConnSM{Disconnected}).
+		RegisterSubState(Connected, Idle, noop, []ConnSM{Busy}).
+		RegisterSubState(Connected, Busy, noop, nil).
+		SetDefaultSubState(Connected, Idle).
+		Validate()
+	assert.Equal(t, []DefinitionError[ConnSM]{
+		{State: Idle, Err: ErrUnreachableState},
+	}, definitionErrors(t, err))
+}
+
+func TestBuildE(t *testing.T) {
+	var journal []string
+	state := func(id ConnSM, routes map[connEvent]ConnSM) *recordingState[ConnSM] {
+		return &recordingState[ConnSM]{id: id, journal: &journal, routes: routes}
+	}
+	sm, err := NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterState(Idle, state(Idle, map[connEvent]ConnSM{"work": Busy}), []ConnSM{Busy}).
+		RegisterState(Busy, state(Busy, nil), nil).
+		SetFinalState(Busy).
+		BuildE()
+	assert.NoError(t, err)
+
+	sm.Start()
+	assert.NoError(t, sm.ProcessEvent(connEvent("work")))
+	assert.Equal(t, Busy, sm.State())
+
+	_, err = NewBuilder[ConnSM]().
+		SetDefaultState(Idle).
+		RegisterSubState(Connected, Idle, state(Idle, nil), nil).
+		BuildE()
+	assert.ErrorIs(t, err, ErrStateNotRegistered)
+}